@@ -0,0 +1,142 @@
+package dependencies
+
+import (
+	"github.com/jfrog/gocmd/utils/cache"
+	"runtime"
+	"sync"
+)
+
+// Default number of transitive dependencies populated concurrently by populateTransitive,
+// when PackageWithDeps.MaxConcurrency is left unset.
+var defaultMaxConcurrency = runtime.NumCPU()
+
+// cache.DependenciesCache.GetMap() returns a plain map that backs the "published" set, which is
+// shared by every PackageWithDeps working the same run. Since populateTransitive now processes
+// siblings concurrently, every read or write of that map must go through cacheMutex.
+var cacheMutex sync.Mutex
+
+// Per-module locks, keyed by Dependency.GetId(), so that the same module is never unzipped and
+// tidied concurrently by two siblings that both reference it transitively.
+var moduleLocks sync.Map
+
+// Guards the entire shell-based 'go mod init'/'go mod tidy'/'go mod graph' flow (see
+// createDependencyAndPrepareModViaShell), which relies on os.Chdir into the dependency's temp
+// directory and so can't safely run on more than one module at a time. moduleLocks alone isn't
+// enough here: it's per-module, while os.Chdir affects the whole process, so two siblings on
+// different modules running the shell path concurrently (e.g. populateTransitive's worker pool with
+// UseShellFallback set) would chdir out from under each other mid-command.
+var chdirMutex sync.Mutex
+
+// Returns the concurrency limit to use for this dependency's transitive-dependency worker pool.
+// Defaults to runtime.NumCPU() when MaxConcurrency wasn't explicitly set. Only meaningful on the root
+// PackageWithDeps of a call tree, since that's the only one whose semaphore() call actually creates
+// the shared semaphore; every descendant inherits the channel that sized, regardless of its own
+// MaxConcurrency.
+func (pwd *PackageWithDeps) concurrencyLimit() int {
+	if pwd.MaxConcurrency > 0 {
+		return pwd.MaxConcurrency
+	}
+	return defaultMaxConcurrency
+}
+
+// Returns the worker-pool semaphore shared by this PackageWithDeps' entire PopulateModAndPublish call
+// tree, creating it sized to concurrencyLimit() on first use. A module graph is walked recursively -
+// populateTransitive calls populateModAndPublish on each transitive dependency, which can itself call
+// populateTransitive on its own transitive dependencies - so without a single shared semaphore, each
+// recursion level would hand out its own fresh concurrencyLimit() worth of concurrency, and a
+// wide-and-deep graph could have concurrencyLimit()^depth goroutines in flight at once instead of
+// being bounded by one limit. setTransitiveDependencies copies the already-created semaphore onto
+// every transitive PackageWithDeps it constructs (see its sharedSemaphore field), so by the time any
+// of them call this, sharedSemaphore is already set and this just returns it - the lazy-init branch
+// below only ever runs once, on the root, before any concurrent recursion has started (see the field's
+// doc comment for why that invariant isn't also enforced with a lock here).
+func (pwd *PackageWithDeps) semaphore() chan struct{} {
+	if pwd.sharedSemaphore == nil {
+		pwd.sharedSemaphore = make(chan struct{}, pwd.concurrencyLimit())
+	}
+	return pwd.sharedSemaphore
+}
+
+// Acquires a slot on the given worker-pool semaphore, blocking until one is free, and returns a
+// function that releases it. Used outside of runWithBoundedConcurrency's own task dispatch - e.g. to
+// bound a single phase of work that runs strictly after that phase's own descendants have already
+// finished and released their slots (see publishDependencyAndPopulateTransitive's publish step) - so
+// that call site doesn't have to hand-roll the same acquire/defer-release pair.
+func acquireSemaphoreSlot(semaphore chan struct{}) func() {
+	semaphore <- struct{}{}
+	return func() { <-semaphore }
+}
+
+// Acquires the lock for the given module id, returning a function that releases it.
+func lockModule(moduleId string) func() {
+	value, _ := moduleLocks.LoadOrStore(moduleId, &sync.Mutex{})
+	mutex := value.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// Runs each of tasks on the given worker-pool semaphore, blocking until every task has completed.
+// Extracted from populateTransitive so the worker-pool shape itself can be exercised directly against
+// a synthetic workload, without real Artifactory/filesystem access (see concurrency_bench_test.go).
+// Takes the semaphore itself, rather than a limit to build a fresh one from, so a caller can share one
+// semaphore across an entire recursive call tree (see PackageWithDeps.semaphore).
+//
+// Each task is handed a release function for its own slot, which it may call before returning to give
+// up that slot early. This matters for a task that recurses back into runWithBoundedConcurrency on
+// this very same semaphore (as populateTransitive's tasks do, by calling PopulateModAndPublish, which
+// can itself call populateTransitive on its own transitive dependencies): if such a task held its slot
+// for its entire duration, it would still be holding it while blocked in the recursive call's
+// wg.Wait(), waiting on descendants that need a slot from the same, now-starved semaphore - a
+// deadlock once the pool is saturated. Calling release before recursing avoids that. A task that
+// doesn't recurse can ignore the argument; its slot is released automatically once it returns.
+func runWithBoundedConcurrency(semaphore chan struct{}, tasks []func(release func())) {
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		task := task
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			var releaseOnce sync.Once
+			release := func() { releaseOnce.Do(func() { <-semaphore }) }
+			defer release()
+			task(release)
+		}()
+	}
+	wg.Wait()
+}
+
+// Returns whether the given module id is marked published in the cache, under cacheMutex.
+func isPublished(dependenciesCache *cache.DependenciesCache, moduleId string) bool {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	return dependenciesCache.GetMap()[moduleId]
+}
+
+// Returns a snapshot copy of the cache's published map, taken under cacheMutex, so callers can
+// range over it without holding the lock for the duration of potentially slow work.
+func snapshotPublished(dependenciesCache *cache.DependenciesCache) map[string]bool {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	snapshot := make(map[string]bool, len(dependenciesCache.GetMap()))
+	for moduleId, published := range dependenciesCache.GetMap() {
+		snapshot[moduleId] = published
+	}
+	return snapshot
+}
+
+// Returns whether the given module id has an entry in the cache at all, under cacheMutex. Used
+// where the map tracks "already seen", as opposed to isPublished's "already published" meaning.
+func cacheHasEntry(dependenciesCache *cache.DependenciesCache, moduleId string) bool {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	_, exists := dependenciesCache.GetMap()[moduleId]
+	return exists
+}
+
+// Sets the cache entry for the given module id, under cacheMutex.
+func cacheSetEntry(dependenciesCache *cache.DependenciesCache, moduleId string, value bool) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	dependenciesCache.GetMap()[moduleId] = value
+}
@@ -0,0 +1,62 @@
+package dependencies
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Size of the synthetic module graph used by the benchmarks below: moduleCount stands in for the
+// total number of transitive modules populateTransitive would have to walk, and fanOut for the
+// worker-pool width (MaxConcurrency) it's bounded to.
+const (
+	syntheticModuleCount = 64
+	syntheticFanOut      = 8
+)
+
+// simulatedModuleWork stands in for the network (Artifactory download/publish) and filesystem
+// (unzip/tidy) work PopulateModAndPublish does for one real module, which can't be exercised here
+// without a live Artifactory and a populated local module cache.
+const simulatedModuleWork = time.Millisecond
+
+// syntheticGraphTasks returns moduleCount no-op "populate this module" tasks, each taking
+// simulatedModuleWork, modeling a synthetic module graph flattened the same way populateTransitive
+// flattens pwd.transitiveDependencies into its worker pool. None of these tasks recurse back into
+// runWithBoundedConcurrency, so they ignore the release argument - it's released automatically once
+// each one returns.
+func syntheticGraphTasks(moduleCount int, populated *int64) []func(release func()) {
+	tasks := make([]func(release func()), moduleCount)
+	for i := 0; i < moduleCount; i++ {
+		tasks[i] = func(release func()) {
+			time.Sleep(simulatedModuleWork)
+			atomic.AddInt64(populated, 1)
+		}
+	}
+	return tasks
+}
+
+func runSyntheticGraph(b *testing.B, moduleCount, limit int) {
+	for i := 0; i < b.N; i++ {
+		var populated int64
+		semaphore := make(chan struct{}, limit)
+		runWithBoundedConcurrency(semaphore, syntheticGraphTasks(moduleCount, &populated))
+		if int(populated) != moduleCount {
+			b.Fatalf("expected %d modules populated, got %d", moduleCount, populated)
+		}
+	}
+}
+
+// BenchmarkPopulateTransitiveSequential runs the synthetic module graph through
+// runWithBoundedConcurrency bounded to a single worker, i.e. populateTransitive's pre-chunk0-3
+// sequential behavior.
+func BenchmarkPopulateTransitiveSequential(b *testing.B) {
+	runSyntheticGraph(b, syntheticModuleCount, 1)
+}
+
+// BenchmarkPopulateTransitiveConcurrent runs the same synthetic module graph bounded to
+// syntheticFanOut concurrent workers, so comparing its ns/op against
+// BenchmarkPopulateTransitiveSequential's shows the speedup the worker pool gives on a graph of
+// syntheticModuleCount modules with fan-out syntheticFanOut.
+func BenchmarkPopulateTransitiveConcurrent(b *testing.B) {
+	runSyntheticGraph(b, syntheticModuleCount, syntheticFanOut)
+}
@@ -0,0 +1,145 @@
+package dependencies
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// maxInFlightTracker returns a task function that records how many instances of itself are running
+// concurrently, and a reader for the high-water mark observed so far. Shared by the tests below so the
+// atomic increment/CAS/decrement bookkeeping only needs to be gotten right in one place.
+func maxInFlightTracker() (task func(release func()), highWaterMark func() int64) {
+	var inFlight, maxInFlight int64
+	task = func(release func()) {
+		current := atomic.AddInt64(&inFlight, 1)
+		for {
+			observedMax := atomic.LoadInt64(&maxInFlight)
+			if current <= observedMax || atomic.CompareAndSwapInt64(&maxInFlight, observedMax, current) {
+				break
+			}
+		}
+		atomic.AddInt64(&inFlight, -1)
+	}
+	highWaterMark = func() int64 { return atomic.LoadInt64(&maxInFlight) }
+	return
+}
+
+// Exercises runWithBoundedConcurrency directly against a task set sized well past the semaphore's
+// capacity, asserting the number of tasks observed running at once never exceeds that capacity. This
+// is the same worker-pool primitive populateTransitive drives, so a regression here (e.g. reverting to
+// a fresh, unbounded channel) would show up as this test failing rather than only as a benchmark
+// number changing.
+func TestRunWithBoundedConcurrencyNeverExceedsSemaphoreSize(t *testing.T) {
+	const limit = 4
+	const taskCount = 40
+	semaphore := make(chan struct{}, limit)
+
+	track, highWaterMark := maxInFlightTracker()
+	tasks := make([]func(release func()), taskCount)
+	for i := range tasks {
+		tasks[i] = track
+	}
+
+	runWithBoundedConcurrency(semaphore, tasks)
+
+	if got := highWaterMark(); got > limit {
+		t.Errorf("expected at most %d tasks running at once, got %d", limit, got)
+	}
+}
+
+// Simulates the shape of a recursive PopulateModAndPublish call tree: a root PackageWithDeps whose
+// semaphore() is shared with two "transitive" PackageWithDeps, each of which in turn runs its own
+// worker pool over further tasks via that same semaphore, mirroring how setTransitiveDependencies
+// copies pwd.semaphore() onto every transitive dependency it builds. None of these tasks recurse
+// further themselves, so this only exercises concurrent, independent callers of
+// runWithBoundedConcurrency sharing one semaphore - see
+// TestRunWithBoundedConcurrencyTaskThatRecursesMustReleaseBeforeRecursing below for the case where a
+// task itself drives another round through the same semaphore. Without sharing one semaphore, each of
+// the three pools below would get its own fresh limit-sized channel, allowing up to 3*limit tasks to
+// run at once instead of limit; this test fails if that regresses.
+func TestSharedSemaphoreBoundsConcurrencyAcrossRecursion(t *testing.T) {
+	const limit = 3
+	root := &PackageWithDeps{MaxConcurrency: limit}
+	child1 := &PackageWithDeps{sharedSemaphore: root.semaphore()}
+	child2 := &PackageWithDeps{sharedSemaphore: root.semaphore()}
+
+	track, highWaterMark := maxInFlightTracker()
+	tasksFor := func() []func(release func()) {
+		tasks := make([]func(release func()), 10)
+		for i := range tasks {
+			tasks[i] = track
+		}
+		return tasks
+	}
+
+	var wg sync.WaitGroup
+	for _, pwd := range []*PackageWithDeps{root, child1, child2} {
+		pwd := pwd
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWithBoundedConcurrency(pwd.semaphore(), tasksFor())
+		}()
+	}
+	wg.Wait()
+
+	if got := highWaterMark(); got > limit {
+		t.Errorf("expected the shared semaphore to cap concurrency at %d across the whole call tree, got %d", limit, got)
+	}
+}
+
+// Reproduces the exact shape populateTransitive drives in production: a task dispatched through
+// runWithBoundedConcurrency that itself calls runWithBoundedConcurrency again, on the very same
+// semaphore, for its own child tasks - modeling populateModAndPublish releasing its slot (see its doc)
+// before recursing into populateTransitive for its transitive dependencies. With a semaphore sized to
+// exactly the tree's width, a task that forgot to release before recursing would still be holding the
+// one slot its own child needs, deadlocking the root's runWithBoundedConcurrency call forever. Run on a
+// timeout so that regression fails the test instead of hanging the suite.
+func TestRunWithBoundedConcurrencyTaskThatRecursesMustReleaseBeforeRecursing(t *testing.T) {
+	const limit = 2
+	semaphore := make(chan struct{}, limit)
+
+	leafTasks := func() []func(release func()) {
+		return []func(release func()){
+			func(release func()) {},
+			func(release func()) {},
+		}
+	}
+	rootTasks := []func(release func()){
+		func(release func()) {
+			release()
+			runWithBoundedConcurrency(semaphore, leafTasks())
+		},
+		func(release func()) {
+			release()
+			runWithBoundedConcurrency(semaphore, leafTasks())
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		runWithBoundedConcurrency(semaphore, rootTasks)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runWithBoundedConcurrency deadlocked: a task that released before recursing on the same semaphore should never block its own descendants")
+	}
+}
+
+// New's UseShellFallback default of false is what lets populateTransitive's worker pool actually
+// deliver concurrency by default: createDependencyAndPrepareModViaShell (the UseShellFallback path)
+// serializes entirely behind chdirMutex for its whole body, so if New ever defaulted this back to
+// true, the worker pool added in chunk0-3 would go back to delivering effectively no speedup over the
+// pre-chunk0-3 sequential code, even though runWithBoundedConcurrency itself runs tasks in parallel.
+func TestNewDefaultsToInProcessTidyingSoSiblingsCanRunConcurrently(t *testing.T) {
+	pwd := &PackageWithDeps{}
+	pwd.New("", Package{})
+	if pwd.UseShellFallback {
+		t.Error("expected New to default UseShellFallback to false, so siblings aren't serialized behind chdirMutex")
+	}
+}
@@ -0,0 +1,53 @@
+package dependencies
+
+import "github.com/jfrog/gocmd/utils/cache"
+
+// These wrap cache.DependenciesCache.AddDiagnostic for each Diagnostic kind this package can hit,
+// so call sites whose own parameter is named "cache" (shadowing the package) don't need to spell
+// out cache.Severity/cache.DiagnosticKind themselves.
+
+func recordDownloadFailure(dependenciesCache *cache.DependenciesCache, moduleId, message, location string) {
+	dependenciesCache.AddDiagnostic(cache.Diagnostic{
+		ModuleId: moduleId,
+		Severity: cache.SeverityError,
+		Kind:     cache.DownloadFailure,
+		Message:  message,
+		Location: location,
+	})
+}
+
+func recordPublishFailure(dependenciesCache *cache.DependenciesCache, moduleId, message, location string) {
+	dependenciesCache.AddDiagnostic(cache.Diagnostic{
+		ModuleId: moduleId,
+		Severity: cache.SeverityError,
+		Kind:     cache.PublishFailure,
+		Message:  message,
+		Location: location,
+	})
+}
+
+// recordMissingRequire records an error-level MissingRequire diagnostic for a dependency whose
+// tidied mod file is missing a require it actually needs, since the import couldn't be resolved to
+// a known module in tidyModInProcess.
+func recordMissingRequire(dependenciesCache *cache.DependenciesCache, moduleId, message, location string) {
+	dependenciesCache.AddDiagnostic(cache.Diagnostic{
+		ModuleId: moduleId,
+		Severity: cache.SeverityError,
+		Kind:     cache.MissingRequire,
+		Message:  message,
+		Location: location,
+	})
+}
+
+// recordModInitWarning records a warning-level SyntaxError diagnostic for a dependency whose mod
+// file failed 'go mod init'/in-process init, noting that prepareUnpublishedDependency fell back to
+// writing the dependency's own mod content rather than failing outright.
+func recordModInitWarning(dependenciesCache *cache.DependenciesCache, moduleId, message, location string) {
+	dependenciesCache.AddDiagnostic(cache.Diagnostic{
+		ModuleId: moduleId,
+		Severity: cache.SeverityWarning,
+		Kind:     cache.SyntaxError,
+		Message:  message,
+		Location: location,
+	})
+}
@@ -0,0 +1,82 @@
+package dependencies
+
+import (
+	"fmt"
+	"github.com/jfrog/gocmd/utils/cache"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+	"github.com/pmezard/go-difflib/difflib"
+	"golang.org/x/mod/modfile"
+	"io/ioutil"
+)
+
+// recordTidyPlanEntry diffs originalModContent (the dependency's mod content before tidying) against
+// the tidied content now sitting at pathToModFile, and records the result as a cache.TidyPlanEntry
+// instead of publishing anything. willPublish reflects whether this module would have been published
+// had DryRun not been set.
+func recordTidyPlanEntry(dependenciesCache *cache.DependenciesCache, moduleId string, originalModContent []byte, pathToModFile string, willPublish bool) {
+	tidiedModContent, err := ioutil.ReadFile(pathToModFile)
+	if err != nil {
+		log.Debug("Could not read the tidied mod file for", moduleId, "while building the dry-run plan:", err.Error())
+		return
+	}
+
+	added, removed := diffRequires(originalModContent, tidiedModContent)
+	diff := unifiedModDiff(moduleId, originalModContent, tidiedModContent)
+
+	dependenciesCache.AddTidyPlanEntry(cache.TidyPlanEntry{
+		ModuleId:    moduleId,
+		Added:       added,
+		Removed:     removed,
+		WillPublish: willPublish,
+		Diff:        diff,
+	})
+}
+
+// diffRequires compares the 'require' directives of originalModContent and tidiedModContent, and
+// returns the module@version entries added and removed between the two. Either mod content that
+// fails to parse is treated as having no requires.
+func diffRequires(originalModContent, tidiedModContent []byte) (added, removed []string) {
+	originalRequires := requireSet(originalModContent)
+	tidiedRequires := requireSet(tidiedModContent)
+	for moduleAndVersion := range tidiedRequires {
+		if !originalRequires[moduleAndVersion] {
+			added = append(added, moduleAndVersion)
+		}
+	}
+	for moduleAndVersion := range originalRequires {
+		if !tidiedRequires[moduleAndVersion] {
+			removed = append(removed, moduleAndVersion)
+		}
+	}
+	return
+}
+
+func requireSet(modContent []byte) map[string]bool {
+	requires := map[string]bool{}
+	modFile, err := modfile.Parse("", modContent, nil)
+	if err != nil {
+		return requires
+	}
+	for _, require := range modFile.Require {
+		requires[require.Mod.Path+"@"+require.Mod.Version] = true
+	}
+	return requires
+}
+
+// unifiedModDiff returns a unified diff between originalModContent and tidiedModContent, labelled
+// with moduleId so a caller printing the full plan can tell entries apart.
+func unifiedModDiff(moduleId string, originalModContent, tidiedModContent []byte) string {
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(originalModContent)),
+		B:        difflib.SplitLines(string(tidiedModContent)),
+		FromFile: fmt.Sprintf("%s/go.mod (original)", moduleId),
+		ToFile:   fmt.Sprintf("%s/go.mod (tidied)", moduleId),
+		Context:  3,
+	}
+	text, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		log.Debug("Could not build a unified diff for", moduleId, ":", err.Error())
+		return ""
+	}
+	return text
+}
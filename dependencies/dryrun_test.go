@@ -0,0 +1,56 @@
+package dependencies
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffRequires(t *testing.T) {
+	original := []byte("module github.com/jfrog/gocmd\n\nrequire (\n\tgithub.com/pkg/errors v0.9.0\n\tgithub.com/stretchr/testify v1.6.0\n)\n")
+	tidied := []byte("module github.com/jfrog/gocmd\n\nrequire (\n\tgithub.com/pkg/errors v0.9.1\n\tgithub.com/stretchr/testify v1.6.0\n)\n")
+
+	added, removed := diffRequires(original, tidied)
+
+	if !contains(added, "github.com/pkg/errors@v0.9.1") {
+		t.Errorf("expected the bumped version to show up as added, got %v", added)
+	}
+	if !contains(removed, "github.com/pkg/errors@v0.9.0") {
+		t.Errorf("expected the old version to show up as removed, got %v", removed)
+	}
+	if contains(added, "github.com/stretchr/testify@v1.6.0") || contains(removed, "github.com/stretchr/testify@v1.6.0") {
+		t.Errorf("expected the unchanged require to show up in neither added nor removed, got added=%v removed=%v", added, removed)
+	}
+}
+
+func TestDiffRequiresUnparsableContentHasNoRequires(t *testing.T) {
+	added, removed := diffRequires([]byte("not a go.mod file"), []byte("module github.com/jfrog/gocmd\n\nrequire github.com/pkg/errors v0.9.1\n"))
+	if len(removed) != 0 {
+		t.Errorf("expected no removed entries when the original content fails to parse, got %v", removed)
+	}
+	if !contains(added, "github.com/pkg/errors@v0.9.1") {
+		t.Errorf("expected the tidied require to show up as added, got %v", added)
+	}
+}
+
+func TestUnifiedModDiff(t *testing.T) {
+	original := []byte("module github.com/jfrog/gocmd\n")
+	tidied := []byte("module github.com/jfrog/gocmd\n\nrequire github.com/pkg/errors v0.9.1\n")
+
+	diff := unifiedModDiff("github.com/jfrog/gocmd:v1.0.0", original, tidied)
+
+	if !strings.Contains(diff, "github.com/jfrog/gocmd:v1.0.0/go.mod (original)") {
+		t.Errorf("expected the diff header to carry the moduleId, got: %s", diff)
+	}
+	if !strings.Contains(diff, "+require github.com/pkg/errors v0.9.1") {
+		t.Errorf("expected the diff to show the added require line, got: %s", diff)
+	}
+}
+
+func contains(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,427 @@
+package dependencies
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jfrog/gocmd/utils/cache"
+	"github.com/jfrog/jfrog-client-go/artifactory/auth"
+	"github.com/jfrog/jfrog-client-go/httpclient"
+	"github.com/jfrog/jfrog-client-go/utils/errorutils"
+	"github.com/jfrog/jfrog-client-go/utils/io/fileutils"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+	"go/parser"
+	"go/token"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// In-process equivalent of 'go mod init' + 'go mod tidy' + 'go mod graph', modeled on gopls' mod_tidy.go.
+// Walks the unzipped dependency source, resolves its imports against dependencies already known to the
+// local cache, falling back to Artifactory itself for an import neither has seen yet, and rewrites the
+// mod file in place. Returns the flattened module@version graph that
+// publishDependencyAndPopulateTransitive expects, without shelling out to the go command or chdir'ing.
+// Consults pwd's TidyCache first, keyed by module id, mod content, import set and targetRepo, so that a
+// repeated invocation over the same inputs skips the resolve/AddRequire/graph-flatten work below
+// entirely on a hit - though not the unzip that already happened by the time this is called, nor the
+// collectImports walk just above, since ImportsHash (the cache key) can only be computed from the
+// already-unzipped source.
+func (pwd *PackageWithDeps) tidyModInProcess(targetRepo, pathToModFile string, cache *cache.DependenciesCache, artDetails auth.ArtifactoryDetails) (map[string]bool, error) {
+	moduleDir := filepath.Dir(pathToModFile)
+	modFile, err := readModFile(pathToModFile)
+	if err != nil {
+		return nil, err
+	}
+
+	imports, err := collectImports(moduleDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if tidiedModContent, graphDeps, found := pwd.tidyCacheGet(targetRepo, imports); found {
+		log.Debug("Reusing cached tidy result for", pwd.Dependency.GetId())
+		if err := writeModContentToModFile(pathToModFile, tidiedModContent); err != nil {
+			return nil, err
+		}
+		return graphDeps, nil
+	}
+
+	direct := map[string]string{}
+	var unresolved []string
+	for _, importPath := range imports {
+		if isStandardLibraryImport(importPath) || strings.HasPrefix(importPath, modFile.Module.Mod.Path) {
+			continue
+		}
+		modulePath, version, found := resolveModuleForImport(importPath, pwd.cachePath, targetRepo, cache, artDetails)
+		if !found {
+			unresolved = append(unresolved, importPath)
+			continue
+		}
+		direct[modulePath] = version
+	}
+	// An import that can't be resolved to a known module even after consulting Artifactory (see
+	// resolveModuleForImport) means the tidied mod file would be missing a require it actually needs.
+	// Rather than silently publishing that under-populated graph, record a MissingRequire diagnostic -
+	// so a --fail-on caller gates on it via cache.HasErrors() - and fail this module.
+	if len(unresolved) > 0 {
+		message := fmt.Sprintf("could not resolve import(s) %s to a known dependency while tidying in-process", strings.Join(unresolved, ", "))
+		recordMissingRequire(cache, pwd.Dependency.GetId(), message, pathToModFile)
+		return nil, errorutils.CheckError(fmt.Errorf(message+" for %s", pwd.Dependency.GetId()))
+	}
+
+	for modulePath, version := range direct {
+		if err := modFile.AddRequire(modulePath, version); err != nil {
+			logError(errorutils.CheckError(err))
+		}
+	}
+	modFile.Cleanup()
+	formatted, err := modFile.Format()
+	if err != nil {
+		return nil, errorutils.CheckError(err)
+	}
+	if err := writeModContentToModFile(pathToModFile, formatted); err != nil {
+		return nil, err
+	}
+
+	graphDeps := collectTransitiveRequires(direct, pwd.cachePath)
+	pwd.tidyCachePut(targetRepo, imports, formatted, graphDeps)
+	return graphDeps, nil
+}
+
+// In-process equivalent of 'go mod init': writes a minimal go.mod containing only the module directive,
+// without chdir'ing or shelling out.
+func (pwd *PackageWithDeps) initModInProcess(pathToModFile string) error {
+	exists, err := fileutils.IsFileExists(pathToModFile, false)
+	if err != nil {
+		return errorutils.CheckError(err)
+	}
+	moduleId := pwd.Dependency.GetId()
+	moduleInfo := strings.SplitN(moduleId, ":", 2)
+	modulePath := replaceExclamationMarkWithUpperCase(moduleInfo[0])
+
+	modFile := &modfile.File{}
+	if err := modFile.AddModuleStmt(modulePath); err != nil {
+		return errorutils.CheckError(err)
+	}
+	markModFileGeneratedByUs(modFile, pwd.GoModEditMessage)
+	modFile.Cleanup()
+	content, err := modFile.Format()
+	if err != nil {
+		return errorutils.CheckError(err)
+	}
+	if exists {
+		if err := os.Remove(pathToModFile); err != nil {
+			return errorutils.CheckError(err)
+		}
+	}
+	return writeModContentToModFile(pathToModFile, content)
+}
+
+// In-process equivalent of writing the cached mod file to the temp project and removing its go.sum,
+// without chdir'ing into the dependency's directory.
+func (pwd *PackageWithDeps) useCachedModInProcess(path string) error {
+	log.Debug("Using the mod in the cache since not empty:", pwd.Dependency.GetId())
+	if err := writeModContentToModFile(path, pwd.Dependency.GetModContent()); err != nil {
+		return err
+	}
+	return removeGoSum(path)
+}
+
+// Builds the module@version graph from a mod file that already has its requires populated,
+// flattening its transitive requires the same way tidyModInProcess does for a freshly tidied one.
+func (pwd *PackageWithDeps) graphFromModFile(path string) (map[string]bool, error) {
+	modFile, err := readModFile(path)
+	if err != nil {
+		return nil, err
+	}
+	direct := map[string]string{}
+	for _, require := range modFile.Require {
+		direct[require.Mod.Path] = require.Mod.Version
+	}
+	return collectTransitiveRequires(direct, pwd.cachePath), nil
+}
+
+// Parses the mod file at the given path with golang.org/x/mod/modfile.
+func readModFile(pathToModFile string) (*modfile.File, error) {
+	content, err := ioutil.ReadFile(pathToModFile)
+	if err != nil {
+		return nil, errorutils.CheckError(err)
+	}
+	modFile, err := modfile.Parse(pathToModFile, content, nil)
+	if err != nil {
+		return nil, errorutils.CheckError(err)
+	}
+	return modFile, nil
+}
+
+// Walks the dependency's source tree and collects the set of distinct package paths it imports.
+// _test.go files are skipped, since they don't ship as part of the published module.
+func collectImports(moduleDir string) ([]string, error) {
+	importSet := map[string]bool{}
+	fset := token.NewFileSet()
+	err := filepath.Walk(moduleDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		file, parseErr := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if parseErr != nil {
+			log.Debug("Skipping unparsable file", path, "while collecting imports:", parseErr.Error())
+			return nil
+		}
+		for _, importSpec := range file.Imports {
+			importSet[strings.Trim(importSpec.Path.Value, `"`)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errorutils.CheckError(err)
+	}
+	imports := make([]string, 0, len(importSet))
+	for importPath := range importSet {
+		imports = append(imports, importPath)
+	}
+	return imports, nil
+}
+
+// An import path belongs to the standard library if its first path element has no dot,
+// e.g. "fmt" or "encoding/json" as opposed to "github.com/jfrog/gocmd".
+func isStandardLibraryImport(importPath string) bool {
+	firstElement := importPath
+	if i := strings.Index(importPath, "/"); i != -1 {
+		firstElement = importPath[:i]
+	}
+	return !strings.Contains(firstElement, ".")
+}
+
+// Resolves an import path to one of the modules already known to this run's published set, by
+// matching the longest known module path that's a prefix of the import path. Falls back to
+// resolveModuleFromLocalCache, since a brand-new dependency being tidied for the first time has
+// imports that this run hasn't published anything for yet, and finally to resolveModuleFromArtifactory
+// for an import neither of those has seen before - the same import-to-module resolution a plain
+// 'go mod tidy' would have gone to GOPROXY for.
+func resolveModuleForImport(importPath, cachePath, targetRepo string, cache *cache.DependenciesCache, artDetails auth.ArtifactoryDetails) (modulePath, version string, found bool) {
+	if modulePath, version, found = resolveModuleFromPublished(importPath, cache); found {
+		return
+	}
+	if modulePath, version, found = resolveModuleFromLocalCache(importPath, cachePath); found {
+		return
+	}
+	if modulePath, version, found = resolveModuleFromArtifactory(importPath, targetRepo, artDetails); found {
+		// Seed the local module cache with the resolved module's mod file, the same way
+		// PopulateModAndPublish does for a dependency it already knows it needs to publish, so a
+		// later collectTransitiveRequires call can flatten this module's own requires by reading it
+		// from cachePath exactly as it does for a module resolveModuleFromLocalCache found directly.
+		downloadModFileFromArtifactoryToLocalCache(cachePath, targetRepo, modulePath, version, artDetails, httpclient.NewDefaultHttpClient())
+	}
+	return
+}
+
+// Resolves importPath against targetRepo's Artifactory-hosted go proxy, by querying progressively
+// shorter prefixes of importPath - the same way the go command itself walks up from an import path to
+// find the module that provides it - for a "@latest" version, stopping at the first prefix Artifactory
+// reports one for. This is the network path resolveModuleForImport falls back to once neither this
+// run's published set nor the local module cache already has an answer.
+func resolveModuleFromArtifactory(importPath, targetRepo string, artDetails auth.ArtifactoryDetails) (modulePath, version string, found bool) {
+	client := httpclient.NewDefaultHttpClient()
+	for _, candidate := range candidateModulePaths(importPath) {
+		if latestVersion, ok := latestVersionFromArtifactory(candidate, targetRepo, artDetails, client); ok {
+			return candidate, latestVersion, true
+		}
+	}
+	return "", "", false
+}
+
+// candidateModulePaths returns importPath and each of its ancestor directories, longest (most
+// specific) first, e.g. "github.com/a/b/c" -> ["github.com/a/b/c", "github.com/a/b", "github.com/a"].
+func candidateModulePaths(importPath string) []string {
+	candidates := []string{importPath}
+	for {
+		idx := strings.LastIndex(importPath, "/")
+		if idx <= 0 {
+			break
+		}
+		importPath = importPath[:idx]
+		candidates = append(candidates, importPath)
+	}
+	return candidates
+}
+
+// latestVersionFromArtifactory queries targetRepo's go proxy API for modulePath's latest version, the
+// same "@latest" endpoint the go command itself queries against GOPROXY.
+func latestVersionFromArtifactory(modulePath, targetRepo string, artDetails auth.ArtifactoryDetails, client *httpclient.HttpClient) (version string, found bool) {
+	escapedModulePath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return "", false
+	}
+	url := strings.TrimSuffix(artDetails.GetUrl(), "/") + "/api/go/" + targetRepo + "/" + escapedModulePath + "/@latest"
+	resp, body, err := client.SendGet(url, true, artDetails.CreateHttpClientDetails())
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return "", false
+	}
+	var latest struct {
+		Version string `json:"Version"`
+	}
+	if err := json.Unmarshal(body, &latest); err != nil || latest.Version == "" {
+		return "", false
+	}
+	return latest.Version, true
+}
+
+// Resolves an import path against the modules this run has already published, by matching the
+// longest known module path that's a prefix of the import path. Module ids in the cache use Go's
+// "!"-lowercase case encoding (e.g. "github.com/!sirupsen/logrus"), while import paths keep their
+// real casing, so each candidate is decoded with replaceExclamationMarkWithUpperCase before comparing.
+func resolveModuleFromPublished(importPath string, cache *cache.DependenciesCache) (modulePath, version string, found bool) {
+	for moduleAndVersion := range snapshotPublished(cache) {
+		parts := strings.SplitN(moduleAndVersion, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		candidateModulePath := replaceExclamationMarkWithUpperCase(parts[0])
+		if candidateModulePath == importPath || strings.HasPrefix(importPath, candidateModulePath+"/") {
+			if len(candidateModulePath) > len(modulePath) {
+				modulePath, version, found = candidateModulePath, parts[1], true
+			}
+		}
+	}
+	return
+}
+
+// Resolves an import path against the modules already unzipped into the local module cache at
+// cachePath, independently of anything this run has published, by matching the longest module path
+// in localCacheIndex(cachePath) that's a prefix of the import path.
+func resolveModuleFromLocalCache(importPath, cachePath string) (modulePath, version string, found bool) {
+	for candidateModulePath, candidateVersion := range localCacheIndex(cachePath) {
+		if candidateModulePath != importPath && !strings.HasPrefix(importPath, candidateModulePath+"/") {
+			continue
+		}
+		if len(candidateModulePath) > len(modulePath) {
+			modulePath, version, found = candidateModulePath, candidateVersion, true
+		}
+	}
+	return
+}
+
+// Module path -> latest cached version indexes built by buildLocalCacheIndex, keyed by cachePath and
+// memoized so a run resolving many imports across many dependencies walks the local module cache once
+// per cachePath rather than once per unresolved import.
+var localCacheIndexes sync.Map // map[string]map[string]string
+
+// Returns cachePath's module path -> latest cached version index, building and memoizing it on first
+// use. A module added to the local cache by this same run after the index was built (e.g. by
+// downloadAndCreateDependency, for a transitive dependency this run just downloaded) won't be
+// reflected here; resolveModuleForImport still finds those via resolveModuleFromPublished first.
+func localCacheIndex(cachePath string) map[string]string {
+	if value, ok := localCacheIndexes.Load(cachePath); ok {
+		return value.(map[string]string)
+	}
+	value, _ := localCacheIndexes.LoadOrStore(cachePath, buildLocalCacheIndex(cachePath))
+	return value.(map[string]string)
+}
+
+// Walks cachePath's GOPATH/pkg/mod-style "<module>/@v/<version>.mod" layout once, indexing every
+// module by its latest cached version. Directory names on disk use Go's "!"-lowercase case encoding,
+// so each one is decoded with replaceExclamationMarkWithUpperCase to match importPath's real casing.
+func buildLocalCacheIndex(cachePath string) map[string]string {
+	index := map[string]string{}
+	err := filepath.Walk(cachePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || info.Name() != "@v" {
+			return nil
+		}
+		relModuleDir, err := filepath.Rel(cachePath, filepath.Dir(path))
+		if err != nil {
+			return nil
+		}
+		modulePath := replaceExclamationMarkWithUpperCase(filepath.ToSlash(relModuleDir))
+		if version, ok := latestCachedVersion(path); ok {
+			index[modulePath] = version
+		}
+		return nil
+	})
+	if err != nil {
+		log.Debug("Error walking the local module cache while indexing it:", err.Error())
+	}
+	return index
+}
+
+// Returns the highest version among the ".mod" files cached under a module's "@v" directory.
+func latestCachedVersion(vDir string) (version string, found bool) {
+	entries, err := ioutil.ReadDir(vDir)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".mod") {
+			continue
+		}
+		candidate := strings.TrimSuffix(entry.Name(), ".mod")
+		if !found || semver.Compare(candidate, version) > 0 {
+			version, found = candidate, true
+		}
+	}
+	return
+}
+
+// Flattens the transitive requires of the resolved direct dependencies, by reading their own cached
+// mod files, mimicking the output of 'go mod graph' without re-invoking it for every dependency.
+func collectTransitiveRequires(direct map[string]string, cachePath string) map[string]bool {
+	graph := map[string]bool{}
+	visited := map[string]bool{}
+	queue := make([]string, 0, len(direct))
+	for modulePath, version := range direct {
+		moduleAndVersion := modulePath + "@" + version
+		graph[moduleAndVersion] = true
+		queue = append(queue, moduleAndVersion)
+	}
+	for len(queue) > 0 {
+		moduleAndVersion := queue[0]
+		queue = queue[1:]
+		if visited[moduleAndVersion] {
+			continue
+		}
+		visited[moduleAndVersion] = true
+		parts := strings.SplitN(moduleAndVersion, "@", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		// direct's keys (and so moduleAndVersion's module path) are real-cased, decoded by
+		// resolveModuleFromPublished/resolveModuleFromLocalCache via replaceExclamationMarkWithUpperCase,
+		// while module-cache directories on disk use Go's "!"-lowercase encoding, so re-encode before
+		// building modCachePath or this never finds a module with an uppercase letter in its path.
+		escapedModulePath, err := module.EscapePath(parts[0])
+		if err != nil {
+			continue
+		}
+		pathToModule := strings.Split(escapedModulePath, "/")
+		modCachePath := filepath.Join(cachePath, strings.Join(pathToModule, string(filepath.Separator)), "@v", parts[1]+".mod")
+		// Deliberately read this module's cached mod file without holding its lock: this read happens
+		// while the caller (tidyModInProcess, via createDependencyAndPrepareMod) is still holding its
+		// own module's lock, so taking a second module's lock here as well can deadlock two workers
+		// tidying a pair of modules that mutually require each other (worker 1 holds A, waits for B;
+		// worker 2 holds B, waits for A). writeModContentToGoCache instead writes via a temp file plus
+		// atomic rename, so this never observes a torn/partial write - at worst a stale-but-complete
+		// version from before a concurrent rewrite, which just means a retry of the outer populate
+		// would pick up any newly-added transitive requires.
+		modFile, err := readModFile(modCachePath)
+		if err != nil {
+			continue
+		}
+		for _, require := range modFile.Require {
+			childModuleAndVersion := require.Mod.Path + "@" + require.Mod.Version
+			graph[childModuleAndVersion] = true
+			if !visited[childModuleAndVersion] {
+				queue = append(queue, childModuleAndVersion)
+			}
+		}
+	}
+	return graph
+}
@@ -0,0 +1,177 @@
+package dependencies
+
+import (
+	"github.com/jfrog/gocmd/utils/cache"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsStandardLibraryImport(t *testing.T) {
+	tests := []struct {
+		importPath string
+		want       bool
+	}{
+		{"fmt", true},
+		{"encoding/json", true},
+		{"github.com/jfrog/gocmd", false},
+		{"github.com/jfrog/gocmd/dependencies", false},
+	}
+	for _, test := range tests {
+		if got := isStandardLibraryImport(test.importPath); got != test.want {
+			t.Errorf("isStandardLibraryImport(%q) = %v, want %v", test.importPath, got, test.want)
+		}
+	}
+}
+
+func TestCollectImports(t *testing.T) {
+	moduleDir := t.TempDir()
+	writeTestFile(t, filepath.Join(moduleDir, "main.go"), `package main
+
+import (
+	"fmt"
+	"github.com/jfrog/gocmd/dependencies"
+)
+
+func main() {
+	fmt.Println(dependencies.Anything)
+}
+`)
+	writeTestFile(t, filepath.Join(moduleDir, "main_test.go"), `package main
+
+import "testing"
+
+func TestMain(t *testing.T) {}
+`)
+
+	imports, err := collectImports(moduleDir)
+	if err != nil {
+		t.Fatalf("collectImports returned an error: %s", err.Error())
+	}
+	importSet := map[string]bool{}
+	for _, importPath := range imports {
+		importSet[importPath] = true
+	}
+	if !importSet["fmt"] || !importSet["github.com/jfrog/gocmd/dependencies"] {
+		t.Errorf("expected collectImports to find both imports from main.go, got %v", imports)
+	}
+	if importSet["testing"] {
+		t.Errorf("expected collectImports to skip _test.go files, got %v", imports)
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed writing test file %s: %s", path, err.Error())
+	}
+}
+
+// resolveModuleForImport's published-set and local-cache checks run before it ever has to reach out
+// to Artifactory, so these two cases can be exercised without an *auth.ArtifactoryDetails - a nil
+// one is never dereferenced as long as one of the two earlier lookups is a hit.
+
+func TestResolveModuleForImportFromPublished(t *testing.T) {
+	dependenciesCache := &cache.DependenciesCache{}
+	cacheSetEntry(dependenciesCache, "github.com/pkg/errors:v0.9.1", true)
+
+	modulePath, version, found := resolveModuleForImport("github.com/pkg/errors/internal", "", "go-remote", dependenciesCache, nil)
+	if !found {
+		t.Fatal("expected resolveModuleForImport to resolve against the published set")
+	}
+	if modulePath != "github.com/pkg/errors" || version != "v0.9.1" {
+		t.Errorf("got modulePath=%q version=%q, want github.com/pkg/errors v0.9.1", modulePath, version)
+	}
+}
+
+func TestResolveModuleForImportFallsBackToLocalCache(t *testing.T) {
+	cachePath := t.TempDir()
+	writeFakeCachedModule(t, cachePath, "github.com/sirupsen/logrus", "v1.8.1")
+
+	dependenciesCache := &cache.DependenciesCache{}
+	modulePath, version, found := resolveModuleForImport("github.com/sirupsen/logrus/hooks/test", cachePath, "go-remote", dependenciesCache, nil)
+	if !found {
+		t.Fatal("expected resolveModuleForImport to fall back to the local module cache")
+	}
+	if modulePath != "github.com/sirupsen/logrus" || version != "v1.8.1" {
+		t.Errorf("got modulePath=%q version=%q, want github.com/sirupsen/logrus v1.8.1", modulePath, version)
+	}
+}
+
+// Neither resolveModuleFromPublished nor resolveModuleFromLocalCache talks to Artifactory, so an
+// import known to neither is exercised directly against them rather than through
+// resolveModuleForImport, which would go on to its Artifactory fallback and need a real
+// *auth.ArtifactoryDetails to do so.
+func TestResolveModuleFromPublishedAndLocalCacheMissForUnknownImport(t *testing.T) {
+	cachePath := t.TempDir()
+	dependenciesCache := &cache.DependenciesCache{}
+
+	if _, _, found := resolveModuleFromPublished("github.com/unknown/module", dependenciesCache); found {
+		t.Error("expected resolveModuleFromPublished to report not found for an import with no known module")
+	}
+	if _, _, found := resolveModuleFromLocalCache("github.com/unknown/module", cachePath); found {
+		t.Error("expected resolveModuleFromLocalCache to report not found for an import with no known module")
+	}
+}
+
+func TestCandidateModulePaths(t *testing.T) {
+	got := candidateModulePaths("github.com/jfrog/gocmd/dependencies")
+	want := []string{
+		"github.com/jfrog/gocmd/dependencies",
+		"github.com/jfrog/gocmd",
+		"github.com/jfrog",
+		"github.com",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("candidate %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+// writeFakeCachedModule writes just enough of the GOPATH/pkg/mod-style "<module>/@v/<version>.mod"
+// layout under cachePath for buildLocalCacheIndex to index it. encodedModulePath must already use
+// Go's "!"-lowercase case encoding, mirroring real module cache directory names.
+func writeFakeCachedModule(t *testing.T, cachePath, encodedModulePath, version string) {
+	t.Helper()
+	vDir := filepath.Join(cachePath, filepath.FromSlash(encodedModulePath), "@v")
+	if err := os.MkdirAll(vDir, 0755); err != nil {
+		t.Fatalf("failed creating fake module cache dir: %s", err.Error())
+	}
+	writeTestFile(t, filepath.Join(vDir, version+".mod"), "module "+encodedModulePath+"\n")
+}
+
+func TestCollectTransitiveRequires(t *testing.T) {
+	cachePath := t.TempDir()
+	writeFakeModWithRequires(t, cachePath, "github.com/transitive/dep", "v2.0.0", nil)
+	writeFakeModWithRequires(t, cachePath, "github.com/direct/dep", "v1.0.0", []string{"github.com/transitive/dep v2.0.0"})
+
+	direct := map[string]string{"github.com/direct/dep": "v1.0.0"}
+	graph := collectTransitiveRequires(direct, cachePath)
+
+	if !graph["github.com/direct/dep@v1.0.0"] {
+		t.Error("expected the direct dependency itself to be in the graph")
+	}
+	if !graph["github.com/transitive/dep@v2.0.0"] {
+		t.Error("expected the direct dependency's own require to be flattened into the graph")
+	}
+}
+
+// writeFakeModWithRequires (over)writes a cached mod file for modulePath@version with the given
+// require lines, e.g. []string{"github.com/foo/bar v1.2.3"}.
+func writeFakeModWithRequires(t *testing.T, cachePath, modulePath, version string, requires []string) {
+	t.Helper()
+	vDir := filepath.Join(cachePath, filepath.FromSlash(modulePath), "@v")
+	if err := os.MkdirAll(vDir, 0755); err != nil {
+		t.Fatalf("failed creating fake module cache dir: %s", err.Error())
+	}
+	content := "module " + modulePath + "\n\ngo 1.16\n"
+	for _, require := range requires {
+		content += "require " + require + "\n"
+	}
+	writeTestFile(t, filepath.Join(vDir, version+".mod"), content)
+}
@@ -1,6 +1,7 @@
 package dependencies
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/jfrog/gocmd/utils"
 	"github.com/jfrog/gocmd/utils/cache"
@@ -11,24 +12,55 @@ import (
 	"github.com/jfrog/jfrog-client-go/utils/errorutils"
 	"github.com/jfrog/jfrog-client-go/utils/io/fileutils"
 	"github.com/jfrog/jfrog-client-go/utils/log"
+	"golang.org/x/mod/modfile"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 )
 
+// Comment attached to the module directive of a go.mod file we generated via 'go mod init'/'go mod tidy',
+// so that we can later tell our own generated mod files apart from mod files provided by the dependency itself.
+const modGeneratedByMarker = "jfrog"
+
 // Represents go dependency when running with deps-tidy set to true.
 type PackageWithDeps struct {
 	Dependency             *Package
 	transitiveDependencies []PackageWithDeps
-	regExp                 *RegExp
-	runGoModCommand        bool
 	shouldRevertToEmptyMod bool
 	TidyEnum               utils.TidyEnum
 	cachePath              string
 	GoModEditMessage       string
 	originalModContent     []byte
+	// When true, fall back to shelling out to 'go mod init'/'go mod tidy'/'go mod graph' for this
+	// dependency and its transitive dependencies, instead of tidying the mod file in-process. The
+	// in-process tidier (see modtidy.go) now resolves an import the same way this flag's shelled-out
+	// path would - this run's published set, then the local module cache, then Artifactory itself -
+	// so New defaults this to false. Kept as an escape hatch for a caller that hits a case the
+	// in-process tidier doesn't handle yet.
+	UseShellFallback bool
+	// Bounds how many of this dependency's transitive dependencies populateTransitive works on at
+	// once. Zero (the default) falls back to runtime.NumCPU().
+	MaxConcurrency int
+	// How long a persisted tidy-cache entry (see tidyModInProcess) remains valid. Zero (the default)
+	// falls back to defaultTidyCacheMaxAge.
+	TidyCacheMaxAge time.Duration
+	// When true, PopulateModAndPublish resolves and tidies the dependency graph as usual but skips
+	// writeModContentToGoCache and prepareAndPublish, recording a TidyPlanEntry on the cache instead.
+	DryRun bool
+	// Worker-pool semaphore shared across this PackageWithDeps' entire PopulateModAndPublish call
+	// tree. Left nil on the root of a tree, lazily created by semaphore() on first use, and copied
+	// onto every transitive PackageWithDeps by setTransitiveDependencies, so the whole recursive graph
+	// is bounded by one concurrencyLimit() instead of each recursion level handing out its own.
+	// semaphore()'s lazy-init check relies on never being called concurrently on the same instance -
+	// true today since it's only ever read on the root, synchronously, before any goroutine fan-out
+	// begins (every other PackageWithDeps gets sharedSemaphore pre-populated by
+	// setTransitiveDependencies rather than creating its own). Guarding that with a mutex isn't free
+	// here: PackageWithDeps is copied by value throughout this package (transitiveDependencies
+	// []PackageWithDeps, populateTransitive's per-task copy), and embedding a lock would make every one
+	// of those copies a go vet copylocks violation.
+	sharedSemaphore chan struct{}
 }
 
 // Creates a new dependency
@@ -36,16 +68,32 @@ func (pwd *PackageWithDeps) New(cachePath string, dependency Package) GoPackage
 	pwd.Dependency = &dependency
 	pwd.cachePath = cachePath
 	pwd.transitiveDependencies = nil
+	pwd.sharedSemaphore = nil
 	return pwd
 }
 
-// Populate the mod file and publish the dependency and it's transitive dependencies to Artifactory
+// Populate the mod file and publish the dependency and it's transitive dependencies to Artifactory.
+// Returns a non-nil error once cache.HasErrors() does, i.e. once any error-severity Diagnostic has
+// been recorded against cache, so a caller can implement "--fail-on" CI-gating semantics by checking
+// this return value instead of having to separately inspect cache.Diagnostics() itself.
 func (pwd *PackageWithDeps) PopulateModAndPublish(targetRepo string, cache *cache.DependenciesCache, serviceManager *artifactory.ArtifactoryServicesManager) error {
+	return pwd.populateModAndPublish(targetRepo, cache, serviceManager, func() {})
+}
+
+// populateModAndPublish is PopulateModAndPublish's actual implementation. release is the worker-pool
+// slot release function runWithBoundedConcurrency hands to populateTransitive's tasks (see there); it
+// is threaded through to publishDependencyAndPopulateTransitive, which calls it once this dependency's
+// own tidy work and setTransitiveDependencies call (both sequential, so safe to hold the slot through)
+// are done, and before recursing into populateTransitive for its own transitive dependencies - so this
+// goroutine never holds a semaphore slot while blocked waiting on descendants that need a slot from
+// that same semaphore. See runWithBoundedConcurrency's doc for why that would deadlock.
+// PopulateModAndPublish itself passes a no-op release, since an external caller invoking it directly
+// isn't part of any worker pool's semaphore to begin with.
+func (pwd *PackageWithDeps) populateModAndPublish(targetRepo string, cache *cache.DependenciesCache, serviceManager *artifactory.ArtifactoryServicesManager, release func()) error {
 	var path string
 	log.Debug("Starting to work on", pwd.Dependency.GetId())
-	serviceManager.GetConfig().GetArtDetails()
-	dependenciesMap := cache.GetMap()
-	published, _ := dependenciesMap[pwd.Dependency.GetId()]
+	artDetails := serviceManager.GetConfig().GetArtDetails()
+	published := isPublished(cache, pwd.Dependency.GetId())
 	if published {
 		log.Debug("Overwriting the mod file in the cache from the one from Artifactory", pwd.Dependency.GetId())
 		moduleAndVersion := strings.Split(pwd.Dependency.GetId(), ":")
@@ -55,13 +103,25 @@ func (pwd *PackageWithDeps) PopulateModAndPublish(targetRepo string, cache *cach
 	}
 
 	// Checks if mod is empty, need to run go mod tidy command to populate the mod file.
-	log.Debug(fmt.Sprintf("Dependency %s mod file is empty: %t", pwd.Dependency.GetId(), !pwd.PatternMatched(pwd.regExp.GetNotEmptyModRegex())))
+	log.Debug(fmt.Sprintf("Dependency %s mod file is empty: %t", pwd.Dependency.GetId(), pwd.isModContentEmpty()))
 
 	// Creates the dependency in the temp folder and runs go commands: go mod tidy and go mod graph.
-	// Returns the path to the project in the temp and the a map with the project dependencies
-	path, output, err := pwd.createDependencyAndPrepareMod(cache)
+	// Returns the path to the project in the temp and the a map with the project dependencies.
+	// Only this tidy/write step is guarded by this module's lock (held via lockModule), so that two
+	// siblings referencing the same transitive module never tidy it concurrently. The lock is
+	// released before recursing into this module's own transitive dependencies below, so a cycle in
+	// the module graph can never deadlock a goroutine waiting on a lock an ancestor still holds.
+	unlock := lockModule(pwd.Dependency.GetId())
+	path, output, err := pwd.createDependencyAndPrepareMod(targetRepo, cache, artDetails)
+	unlock()
 	logError(err)
-	pwd.publishDependencyAndPopulateTransitive(path, targetRepo, output, cache, serviceManager)
+	pwd.publishDependencyAndPopulateTransitive(path, targetRepo, output, err, cache, serviceManager, release)
+	if err != nil {
+		return errorutils.CheckError(fmt.Errorf("failed populating %s: %s", pwd.Dependency.GetId(), err.Error()))
+	}
+	if cache.HasErrors() {
+		return errorutils.CheckError(fmt.Errorf("encountered error-severity diagnostics while populating %s; see cache.Diagnostics() for details", pwd.Dependency.GetId()))
+	}
 	return nil
 }
 
@@ -71,6 +131,7 @@ func (pwd *PackageWithDeps) updateModContent(path string, cache *cache.Dependenc
 		modContent, err := ioutil.ReadFile(path)
 		if err != nil {
 			cache.IncrementFailures()
+			recordDownloadFailure(cache, pwd.Dependency.GetId(), "failed reading the mod file downloaded from Artifactory: "+err.Error(), path)
 			return errorutils.CheckError(err)
 		}
 		pwd.Dependency.SetModContent(modContent)
@@ -80,44 +141,111 @@ func (pwd *PackageWithDeps) updateModContent(path string, cache *cache.Dependenc
 
 // Init the dependency information if needed.
 func (pwd *PackageWithDeps) Init() error {
-	var err error
-	pwd.regExp, err = GetRegex()
+	return nil
+}
+
+// Parses the dependency's current mod content into a *modfile.File.
+func (pwd *PackageWithDeps) parseModFile() (*modfile.File, error) {
+	return modfile.Parse(pwd.Dependency.GetId(), pwd.Dependency.modContent, nil)
+}
+
+// Returns true if the dependency's mod file has no 'require', 'replace' or 'exclude' directives,
+// meaning it still needs to be populated by running 'go mod init'/'go mod tidy'.
+func (pwd *PackageWithDeps) isModContentEmpty() bool {
+	modFile, err := pwd.parseModFile()
 	if err != nil {
-		return err
+		logError(errorutils.CheckError(err))
+		return true
 	}
-	return nil
+	return isModFileEmpty(modFile)
+}
+
+// Returns true if the given mod file has no 'require', 'replace' or 'exclude' directives.
+func isModFileEmpty(modFile *modfile.File) bool {
+	return len(modFile.Require) == 0 && len(modFile.Replace) == 0 && len(modFile.Exclude) == 0
+}
+
+// Returns true if the dependency's mod file was generated by us, by looking for our marker comment
+// on the module directive, rather than scanning the raw mod content for a matching line.
+func (pwd *PackageWithDeps) isModContentGeneratedByUs() bool {
+	modFile, err := pwd.parseModFile()
+	if err != nil {
+		logError(errorutils.CheckError(err))
+		return false
+	}
+	return isModFileGeneratedByUs(modFile, pwd.Dependency.GetModContent(), pwd.GoModEditMessage)
 }
 
-// Returns true if regex found a match otherwise false.
-func (pwd *PackageWithDeps) PatternMatched(regExp *regexp.Regexp) bool {
-	lines := strings.Split(string(pwd.Dependency.modContent), "\n")
-	for _, line := range lines {
-		if regExp.FindString(line) != "" {
-			return true
+// Returns true if the given mod file carries our exact generated-by marker comment on the module
+// directive, or, failing that, if rawModContent carries the legacy (pre-chunk0-1, regex-based tool)
+// marker: that version never attached a modfile comment at all, it simply prepended
+// "<editMessage>\n\n" to the raw mod content, so we check for that literal prefix as a fallback, to
+// keep recognizing already-published dependencies whose mod file predates this marker format.
+func isModFileGeneratedByUs(modFile *modfile.File, rawModContent []byte, editMessage string) bool {
+	if modFile.Module != nil && modFile.Module.Syntax != nil {
+		expected := generatedByCommentToken(editMessage)
+		for _, comment := range modFile.Module.Syntax.Comment().Before {
+			if comment.Token == expected {
+				return true
+			}
 		}
 	}
-	return false
+	return editMessage != "" && bytes.HasPrefix(rawModContent, []byte(editMessage))
 }
 
-// Creates the dependency in the temp folder and runs go mod tidy and go mod graph
-// Returns the path to the project in the temp and the a map with the project dependencies
-func (pwd *PackageWithDeps) createDependencyAndPrepareMod(cache *cache.DependenciesCache) (path string, output map[string]bool, err error) {
+// generatedByCommentToken is the exact modfile.Comment token markModFileGeneratedByUs attaches to
+// the module directive, and the one isModFileGeneratedByUs matches against exactly rather than
+// matching modGeneratedByMarker as a substring, since the latter could also appear in an unrelated
+// comment the dependency shipped with its own mod file.
+func generatedByCommentToken(editMessage string) string {
+	return fmt.Sprintf("// %s (%s)", editMessage, modGeneratedByMarker)
+}
+
+// Attaches our generated-by marker, as a modfile.Comment, to the module directive of the given mod file,
+// so that the marker survives round-tripping through 'go mod' commands.
+func markModFileGeneratedByUs(modFile *modfile.File, editMessage string) {
+	if modFile.Module == nil || modFile.Module.Syntax == nil {
+		return
+	}
+	comment := modfile.Comment{Token: generatedByCommentToken(editMessage)}
+	modFile.Module.Syntax.Comment().Before = append(modFile.Module.Syntax.Comment().Before, comment)
+}
+
+// Creates the dependency in the temp folder and tidies its mod file, populating the dependency graph.
+// Returns the path to the project in the temp and the a map with the project dependencies.
+// Tidies the mod in-process by default; set UseShellFallback to fall back to shelling out to the go command.
+func (pwd *PackageWithDeps) createDependencyAndPrepareMod(targetRepo string, cache *cache.DependenciesCache, artDetails auth.ArtifactoryDetails) (path string, output map[string]bool, err error) {
 	path, err = pwd.getModPathAndUnzipDependency(path)
 	if err != nil {
 		return
 	}
 	pwd.shouldRevertToEmptyMod = false
+	if pwd.UseShellFallback {
+		output, err = pwd.createDependencyAndPrepareModViaShell(path, cache)
+		return
+	}
+	output, err = pwd.createDependencyAndPrepareModInProcess(targetRepo, path, cache, artDetails)
+	return
+}
+
+// Legacy flow: unzips the dependency into a temp dir, chdirs into it, and shells out to
+// 'go mod init'/'go mod tidy'/'go mod graph'. Kept as a fallback for callers that set UseShellFallback.
+// Runs under chdirMutex for its entire body, since every step here depends on the process's current
+// directory and populateTransitive's worker pool can otherwise be tidying several siblings at once.
+func (pwd *PackageWithDeps) createDependencyAndPrepareModViaShell(path string, cache *cache.DependenciesCache) (output map[string]bool, err error) {
+	chdirMutex.Lock()
+	defer chdirMutex.Unlock()
 	// Check the mod in the cache if empty or not
-	if pwd.PatternMatched(pwd.regExp.GetNotEmptyModRegex()) {
+	if !pwd.isModContentEmpty() {
 		err = pwd.useCachedMod(path)
 		if err != nil {
 			return
 		}
 	} else {
-		published, _ := cache.GetMap()[pwd.Dependency.GetId()]
+		published := isPublished(cache, pwd.Dependency.GetId())
 		var originalModContent []byte
 		if !published {
-			originalModContent = pwd.prepareUnpublishedDependency(path, originalModContent)
+			originalModContent = pwd.prepareUnpublishedDependency(path, originalModContent, cache)
 		} else {
 			originalModContent = pwd.Dependency.GetModContent()
 			// Put the mod file to temp
@@ -126,7 +254,7 @@ func (pwd *PackageWithDeps) createDependencyAndPrepareMod(cache *cache.Dependenc
 		}
 		// If not empty --> use the mod file and don't run go mod tidy
 		// If empty --> Run go mod tidy. Publish the package with empty mod file.
-		if !pwd.PatternMatched(pwd.regExp.GetNotEmptyModRegex()) {
+		if pwd.isModContentEmpty() {
 			log.Debug("The mod still empty after running 'go mod init' for:", pwd.Dependency.GetId())
 			err = populateModWithTidy(path)
 			logError(err)
@@ -134,19 +262,64 @@ func (pwd *PackageWithDeps) createDependencyAndPrepareMod(cache *cache.Dependenc
 			pwd.shouldRevertToEmptyMod = true
 			pwd.originalModContent = originalModContent
 		} else {
-			log.Debug("Project mod file after init is not empty", pwd.Dependency.id)
+			log.Debug("Project mod file after init is not empty", pwd.Dependency.GetId())
 		}
 	}
 	output, err = runGoModGraph()
 	return
 }
 
-func (pwd *PackageWithDeps) prepareUnpublishedDependency(pathToModFile string, originalModContent []byte) []byte {
-	err := pwd.prepareAndRunInit(pathToModFile)
+// Default flow: tidies the dependency's mod file in-process, without chdir'ing or shelling out,
+// so PopulateModAndPublish stays safe to call concurrently.
+func (pwd *PackageWithDeps) createDependencyAndPrepareModInProcess(targetRepo, path string, cache *cache.DependenciesCache, artDetails auth.ArtifactoryDetails) (output map[string]bool, err error) {
+	// Check the mod in the cache if empty or not
+	if !pwd.isModContentEmpty() {
+		err = pwd.useCachedModInProcess(path)
+		if err != nil {
+			return
+		}
+		output, err = pwd.graphFromModFile(path)
+		return
+	}
+
+	published := isPublished(cache, pwd.Dependency.GetId())
+	var originalModContent []byte
+	if !published {
+		originalModContent = pwd.prepareUnpublishedDependency(path, originalModContent, cache)
+	} else {
+		originalModContent = pwd.Dependency.GetModContent()
+		// Put the mod file to temp
+		err = writeModContentToModFile(path, pwd.Dependency.GetModContent())
+		logError(err)
+	}
+	// If not empty --> use the mod file and don't run go mod tidy
+	// If empty --> tidy in-process. Publish the package with empty mod file.
+	if pwd.isModContentEmpty() {
+		log.Debug("The mod still empty after init for:", pwd.Dependency.GetId())
+		output, err = pwd.tidyModInProcess(targetRepo, path, cache, artDetails)
+		logError(err)
+		// Need to remember here to revert to the empty mod file.
+		pwd.shouldRevertToEmptyMod = true
+		pwd.originalModContent = originalModContent
+	} else {
+		log.Debug("Project mod file after init is not empty", pwd.Dependency.GetId())
+		output, err = pwd.graphFromModFile(path)
+	}
+	return
+}
+
+func (pwd *PackageWithDeps) prepareUnpublishedDependency(pathToModFile string, originalModContent []byte, cache *cache.DependenciesCache) []byte {
+	var err error
+	if pwd.UseShellFallback {
+		err = pwd.prepareAndRunInit(pathToModFile)
+	} else {
+		err = pwd.initModInProcess(pathToModFile)
+	}
 	if err != nil {
 		log.Error(err)
-		exists, err := fileutils.IsFileExists(pathToModFile, false)
-		logError(err)
+		recordModInitWarning(cache, pwd.Dependency.GetId(), "'go mod init' failed, falling back to the dependency's own mod content: "+err.Error(), pathToModFile)
+		exists, existsErr := fileutils.IsFileExists(pathToModFile, false)
+		logError(existsErr)
 		if !exists {
 			// Create a mod file
 			err = writeModContentToModFile(pathToModFile, pwd.Dependency.GetModContent())
@@ -161,6 +334,9 @@ func (pwd *PackageWithDeps) prepareUnpublishedDependency(pathToModFile string, o
 	return originalModContent
 }
 
+// Legacy counterpart of useCachedModInProcess: writes the cached mod content to the temp project,
+// chdirs into it (the shelled-out go mod commands that follow need it as the working directory), and
+// removes its go.sum.
 func (pwd *PackageWithDeps) useCachedMod(path string) error {
 	// Mod not empty in the cache. Use it.
 	log.Debug("Using the mod in the cache since not empty:", pwd.Dependency.GetId())
@@ -188,6 +364,8 @@ func (pwd *PackageWithDeps) getModPathAndUnzipDependency(path string) (string, e
 	return path, err
 }
 
+// Legacy counterpart of initModInProcess: chdirs into the dependency's temp directory and shells out
+// to 'go mod init'.
 func (pwd *PackageWithDeps) prepareAndRunInit(pathToModFile string) error {
 	log.Debug("Preparing to init", pathToModFile)
 	err := os.Chdir(filepath.Dir(pathToModFile))
@@ -221,43 +399,87 @@ func (pwd *PackageWithDeps) getModPathInTemp(tempDir string) string {
 	return path
 }
 
-func (pwd *PackageWithDeps) publishDependencyAndPopulateTransitive(pathToModFile, targetRepo string, graphDependencies map[string]bool, cache *cache.DependenciesCache, serviceManager *artifactory.ArtifactoryServicesManager) error {
-	// If the mod is not empty, populate transitive dependencies
-	if len(graphDependencies) > 0 {
-		sumFileContent , sumFileStat, err := cmd.GetSumContentAndRemove(filepath.Dir(pathToModFile))
-		logError(err)
-		pwd.setTransitiveDependencies(targetRepo, graphDependencies, cache, serviceManager.GetConfig().GetArtDetails())
-		if len(sumFileContent) > 0 && sumFileStat != nil {
-			cmd.RestoreSumFile(filepath.Dir(pathToModFile), sumFileContent, sumFileStat)
+func (pwd *PackageWithDeps) publishDependencyAndPopulateTransitive(pathToModFile, targetRepo string, graphDependencies map[string]bool, tidyErr error, cache *cache.DependenciesCache, serviceManager *artifactory.ArtifactoryServicesManager, release func()) error {
+	// tidyErr means createDependencyAndPrepareMod failed to fully populate this dependency's mod
+	// file (e.g. an unresolved import - see tidyModInProcess), so graphDependencies is incomplete.
+	// Publishing it regardless would ship an under-populated dependency graph, so skip straight to
+	// cleanup instead.
+	if tidyErr == nil {
+		// If the mod is not empty, populate transitive dependencies
+		if len(graphDependencies) > 0 {
+			sumFileContent, sumFileStat, err := cmd.GetSumContentAndRemove(filepath.Dir(pathToModFile))
+			logError(err)
+			pwd.setTransitiveDependencies(targetRepo, graphDependencies, cache, serviceManager.GetConfig().GetArtDetails())
+			if len(sumFileContent) > 0 && sumFileStat != nil {
+				cmd.RestoreSumFile(filepath.Dir(pathToModFile), sumFileContent, sumFileStat)
+			}
 		}
-	}
 
-	published, _ := cache.GetMap()[pwd.Dependency.GetId()]
-	if !published && (pwd.PatternMatched(pwd.regExp.GetNotEmptyModRegex()) || pwd.PatternMatched(pwd.regExp.GetGeneratedBy())) {
-		err := pwd.writeModContentToGoCache()
-		logError(err)
-	}
+		published := isPublished(cache, pwd.Dependency.GetId())
 
-	// Populate and publish the transitive dependencies.
-	if pwd.transitiveDependencies != nil {
-		pwd.populateTransitive(targetRepo, cache, serviceManager)
-	}
+		if pwd.DryRun {
+			// pwd.Dependency's mod content was overwritten with the blank post-init mod by
+			// prepareUnpublishedDependency before tidying; pwd.originalModContent (stashed there for the
+			// same reason useCachedModInProcess's revert path needs it) is the dependency's real original
+			// mod whenever tidying actually ran, so diff against that instead when it's available.
+			originalModContent := pwd.Dependency.GetModContent()
+			if pwd.shouldRevertToEmptyMod {
+				originalModContent = pwd.originalModContent
+			}
+			recordTidyPlanEntry(cache, pwd.Dependency.GetId(), originalModContent, pathToModFile, !published)
+		} else if !published && (!pwd.isModContentEmpty() || pwd.isModContentGeneratedByUs()) {
+			err := pwd.writeModContentToGoCache(cache)
+			logError(err)
+		}
+
+		// This dependency's own worker-pool slot (if any - see release's doc above) is no longer
+		// needed past this point: setTransitiveDependencies above is sequential and the DryRun/
+		// writeModContentToGoCache steps above don't touch pwd.semaphore() either, so it's safe to
+		// give the slot up now, before populateTransitive goes on to recurse.
+		release()
 
-	if !published && pwd.shouldRevertToEmptyMod {
-		log.Debug("Reverting to the original mod of", pwd.Dependency.GetId())
-		editedBy := pwd.regExp.GetGeneratedBy()
-		if editedBy.FindString(string(pwd.originalModContent)) == "" {
-			pwd.originalModContent = append([]byte(pwd.GoModEditMessage+"\n\n"), pwd.originalModContent...)
+		// Populate and publish the transitive dependencies.
+		if pwd.transitiveDependencies != nil {
+			pwd.populateTransitive(targetRepo, cache, serviceManager)
 		}
-		writeModContentToModFile(pathToModFile, pwd.originalModContent)
-		pwd.Dependency.SetModContent(pwd.originalModContent)
-		err := pwd.writeModContentToGoCache()
-		logError(err)
-	}
-	// Publish to Artifactory the dependency if needed.
-	if !published {
-		err := pwd.prepareAndPublish(targetRepo, cache, serviceManager)
-		logError(err)
+
+		if !pwd.DryRun {
+			if !published && pwd.shouldRevertToEmptyMod {
+				log.Debug("Reverting to the original mod of", pwd.Dependency.GetId())
+				originalModFile, err := modfile.Parse(pwd.Dependency.GetId(), pwd.originalModContent, nil)
+				if err == nil && !isModFileGeneratedByUs(originalModFile, pwd.originalModContent, pwd.GoModEditMessage) {
+					markModFileGeneratedByUs(originalModFile, pwd.GoModEditMessage)
+					originalModFile.Cleanup()
+					if formatted, formatErr := originalModFile.Format(); formatErr == nil {
+						pwd.originalModContent = formatted
+					} else {
+						logError(errorutils.CheckError(formatErr))
+					}
+				} else {
+					logError(errorutils.CheckError(err))
+				}
+				writeModContentToModFile(pathToModFile, pwd.originalModContent)
+				pwd.Dependency.SetModContent(pwd.originalModContent)
+				err := pwd.writeModContentToGoCache(cache)
+				logError(err)
+			}
+			// Publish to Artifactory the dependency if needed. By this point populateTransitive above
+			// (if it ran) has already returned, so every descendant has released its worker-pool slot
+			// back to pwd.semaphore() - acquiring one here to bound concurrent publish calls is safe
+			// and can't deadlock the way holding one across that earlier recursive call would have.
+			if !published {
+				err := func() error {
+					defer acquireSemaphoreSlot(pwd.semaphore())()
+					return pwd.prepareAndPublish(targetRepo, cache, serviceManager)
+				}()
+				logError(err)
+				if err != nil {
+					recordPublishFailure(cache, pwd.Dependency.GetId(), "failed publishing the dependency to Artifactory: "+err.Error(), targetRepo)
+				}
+			}
+		}
+	} else {
+		log.Debug("Skipping publish of", pwd.Dependency.GetId(), "since preparing its mod file failed:", tidyErr.Error())
 	}
 
 	// Remove from temp folder the dependency.
@@ -273,7 +495,7 @@ func (pwd *PackageWithDeps) publishDependencyAndPopulateTransitive(pathToModFile
 // Mark this dependency as published
 func (pwd *PackageWithDeps) prepareAndPublish(targetRepo string, cache *cache.DependenciesCache, serviceManager *artifactory.ArtifactoryServicesManager) error {
 	err := pwd.Dependency.prepareAndPublish(targetRepo, cache, serviceManager)
-	cache.GetMap()[pwd.Dependency.GetId()] = true
+	cacheSetEntry(cache, pwd.Dependency.GetId(), true)
 	return err
 }
 
@@ -282,14 +504,15 @@ func (pwd *PackageWithDeps) setTransitiveDependencies(targetRepo string, graphDe
 	for transitiveDependency := range graphDependencies {
 		module := strings.Split(transitiveDependency, "@")
 		if len(module) == 2 {
-			dependenciesMap := cache.GetMap()
 			name := getDependencyName(module[0])
-			_, exists := dependenciesMap[name+":"+module[1]]
+			moduleId := name + ":" + module[1]
+			exists := cacheHasEntry(cache, moduleId)
 			if !exists {
 				// Check if the dependency is in the local cache.
 				dep, err := createDependency(pwd.cachePath, name, module[1])
 				logError(err)
 				if err != nil {
+					recordDownloadFailure(cache, moduleId, "failed reading transitive dependency from the local cache: "+err.Error(), pwd.cachePath)
 					continue
 				}
 				// Check if this dependency exists in Artifactory.
@@ -297,6 +520,7 @@ func (pwd *PackageWithDeps) setTransitiveDependencies(targetRepo string, graphDe
 				downloadedFromArtifactory, err := shouldDownloadFromArtifactory(module[0], module[1], targetRepo, auth, client)
 				logError(err)
 				if err != nil {
+					recordDownloadFailure(cache, moduleId, "failed checking whether the transitive dependency exists in Artifactory: "+err.Error(), targetRepo)
 					continue
 				}
 				if dep == nil {
@@ -304,6 +528,7 @@ func (pwd *PackageWithDeps) setTransitiveDependencies(targetRepo string, graphDe
 					dep, err = downloadAndCreateDependency(pwd.cachePath, name, module[1], transitiveDependency, targetRepo, downloadedFromArtifactory, auth)
 					logError(err)
 					if err != nil {
+						recordDownloadFailure(cache, moduleId, "failed downloading the transitive dependency: "+err.Error(), targetRepo)
 						continue
 					}
 				}
@@ -311,12 +536,16 @@ func (pwd *PackageWithDeps) setTransitiveDependencies(targetRepo string, graphDe
 				if dep != nil {
 					log.Debug(fmt.Sprintf("Dependency %s has transitive dependency %s", pwd.Dependency.GetId(), dep.GetId()))
 					depsWithTrans := &PackageWithDeps{Dependency: dep,
-						regExp:           pwd.regExp,
 						cachePath:        pwd.cachePath,
 						TidyEnum:         pwd.TidyEnum,
-						GoModEditMessage: pwd.GoModEditMessage}
+						GoModEditMessage: pwd.GoModEditMessage,
+						MaxConcurrency:   pwd.MaxConcurrency,
+						TidyCacheMaxAge:  pwd.TidyCacheMaxAge,
+						DryRun:           pwd.DryRun,
+						UseShellFallback: pwd.UseShellFallback,
+						sharedSemaphore:  pwd.semaphore()}
 					dependencies = append(dependencies, *depsWithTrans)
-					dependenciesMap[name+":"+module[1]] = downloadedFromArtifactory
+					cacheSetEntry(cache, moduleId, downloadedFromArtifactory)
 				}
 			} else {
 				log.Debug("Dependency", transitiveDependency, "has been previously added.")
@@ -326,25 +555,77 @@ func (pwd *PackageWithDeps) setTransitiveDependencies(targetRepo string, graphDe
 	pwd.transitiveDependencies = dependencies
 }
 
-func (pwd *PackageWithDeps) writeModContentToGoCache() error {
+// writeModContentToGoCache writes this module's mod content to its cached ".mod" file. It writes to
+// a temp file in the same directory and renames it into place, rather than taking this module's lock
+// (see collectTransitiveRequires for why that lock was dropped), so a concurrent reader in another
+// worker's collectTransitiveRequires call never observes a partially-written file: os.Rename is
+// atomic, so it either sees this write's full content or whatever was there before it.
+func (pwd *PackageWithDeps) writeModContentToGoCache(cache *cache.DependenciesCache) error {
 	moduleAndVersion := strings.Split(pwd.Dependency.GetId(), ":")
 	pathToModule := strings.Split(moduleAndVersion[0], "/")
-	path := filepath.Join(pwd.cachePath, strings.Join(pathToModule, fileutils.GetFileSeparator()), "@v", moduleAndVersion[1]+".mod")
-	err := ioutil.WriteFile(path, pwd.Dependency.GetModContent(), 0700)
+	dir := filepath.Join(pwd.cachePath, strings.Join(pathToModule, fileutils.GetFileSeparator()), "@v")
+	path := filepath.Join(dir, moduleAndVersion[1]+".mod")
+	err := writeFileAtomically(dir, path, pwd.Dependency.GetModContent())
+	if err != nil {
+		recordPublishFailure(cache, pwd.Dependency.GetId(), "failed writing the mod file to the go module cache: "+err.Error(), path)
+	}
 	return errorutils.CheckError(err)
 }
 
-// Runs over the transitive dependencies, populate the mod files of those transitive dependencies
+// writeFileAtomically writes content to a temp file inside dir, then renames it to path, so a
+// concurrent reader of path never sees a partial write.
+func writeFileAtomically(dir, path string, content []byte) error {
+	tempFile, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return errorutils.CheckError(err)
+	}
+	tempPath := tempFile.Name()
+	_, writeErr := tempFile.Write(content)
+	closeErr := tempFile.Close()
+	if writeErr != nil {
+		os.Remove(tempPath)
+		return errorutils.CheckError(writeErr)
+	}
+	if closeErr != nil {
+		os.Remove(tempPath)
+		return errorutils.CheckError(closeErr)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return errorutils.CheckError(err)
+	}
+	return nil
+}
+
+// Runs over the transitive dependencies, populating the mod files of those transitive dependencies
+// on a worker pool bounded by pwd.semaphore(), instead of one at a time. That semaphore is shared
+// across this entire call tree rather than sized fresh per call, so a wide-and-deep module graph stays
+// bounded by one concurrencyLimit() even though populateTransitive recurses into populateModAndPublish,
+// which can itself call populateTransitive again. Each task gives up its slot (via release, see
+// runWithBoundedConcurrency) once populateModAndPublish's own tidy work and setTransitiveDependencies
+// call are done - both sequential, so safe to hold the slot through - and before it recurses into its
+// own transitive dependencies, rather than holding it for the task's whole duration - otherwise every
+// goroutine blocked in this function's wg.Wait() on its descendants would still be holding a slot those
+// very descendants need from the same semaphore, deadlocking the pool.
+// Per-module exclusion for the actual tidy/write step happens inside populateModAndPublish itself (see
+// lockModule there); it isn't held across this whole call, since a module-graph cycle would then have
+// an ancestor goroutine block in wg.Wait() on a descendant that needs a lock the ancestor is still
+// holding.
 func (pwd *PackageWithDeps) populateTransitive(targetRepo string, cache *cache.DependenciesCache, serviceManager *artifactory.ArtifactoryServicesManager) {
 	cache.IncrementTotal(len(pwd.transitiveDependencies))
+	tasks := make([]func(release func()), 0, len(pwd.transitiveDependencies))
 	for _, transitiveDep := range pwd.transitiveDependencies {
-		published, _ := cache.GetMap()[transitiveDep.Dependency.GetId()]
-		if !published {
-			log.Debug("Starting to work on transitive dependency:", transitiveDep.Dependency.GetId())
-			transitiveDep.PopulateModAndPublish(targetRepo, cache, serviceManager)
-		} else {
-			cache.IncrementSuccess()
-			log.Debug("The dependency", transitiveDep.Dependency.GetId(), "was already handled")
-		}
+		transitiveDep := transitiveDep
+		tasks = append(tasks, func(release func()) {
+			if !isPublished(cache, transitiveDep.Dependency.GetId()) {
+				log.Debug("Starting to work on transitive dependency:", transitiveDep.Dependency.GetId())
+				transitiveDep.populateModAndPublish(targetRepo, cache, serviceManager, release)
+			} else {
+				release()
+				cache.IncrementSuccess()
+				log.Debug("The dependency", transitiveDep.Dependency.GetId(), "was already handled")
+			}
+		})
 	}
+	runWithBoundedConcurrency(pwd.semaphore(), tasks)
 }
@@ -0,0 +1,70 @@
+package dependencies
+
+import (
+	"golang.org/x/mod/modfile"
+	"testing"
+)
+
+func parseTestModFile(t *testing.T, content string) *modfile.File {
+	t.Helper()
+	modFile, err := modfile.Parse("go.mod", []byte(content), nil)
+	if err != nil {
+		t.Fatalf("failed parsing test mod content: %s", err.Error())
+	}
+	return modFile
+}
+
+func TestIsModFileEmpty(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"module directive only", "module github.com/jfrog/gocmd\n", true},
+		{"has a require", "module github.com/jfrog/gocmd\n\nrequire github.com/pkg/errors v0.9.1\n", false},
+		{"has a replace", "module github.com/jfrog/gocmd\n\nreplace github.com/pkg/errors => github.com/pkg/errors v0.9.1\n", false},
+		{"has an exclude", "module github.com/jfrog/gocmd\n\nexclude github.com/pkg/errors v0.9.1\n", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			modFile := parseTestModFile(t, test.content)
+			if got := isModFileEmpty(modFile); got != test.want {
+				t.Errorf("isModFileEmpty() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsModFileGeneratedByUs(t *testing.T) {
+	const editMessage = "jfrog-generated"
+
+	t.Run("carries our marker comment", func(t *testing.T) {
+		modFile := parseTestModFile(t, "module github.com/jfrog/gocmd\n")
+		markModFileGeneratedByUs(modFile, editMessage)
+		if !isModFileGeneratedByUs(modFile, nil, editMessage) {
+			t.Error("expected a mod file marked by markModFileGeneratedByUs to be recognized as generated by us")
+		}
+	})
+
+	t.Run("unrelated comment on the module directive is not mistaken for our marker", func(t *testing.T) {
+		modFile := parseTestModFile(t, "// some unrelated comment mentioning "+modGeneratedByMarker+"\nmodule github.com/jfrog/gocmd\n")
+		if isModFileGeneratedByUs(modFile, nil, editMessage) {
+			t.Error("expected a substring match on modGeneratedByMarker to not count as our exact marker")
+		}
+	})
+
+	t.Run("legacy raw-content prefix fallback", func(t *testing.T) {
+		modFile := parseTestModFile(t, "module github.com/jfrog/gocmd\n")
+		rawModContent := []byte(editMessage + "\n\nmodule github.com/jfrog/gocmd\n")
+		if !isModFileGeneratedByUs(modFile, rawModContent, editMessage) {
+			t.Error("expected the legacy raw-content prefix to be recognized as generated by us")
+		}
+	})
+
+	t.Run("neither marker nor legacy prefix present", func(t *testing.T) {
+		modFile := parseTestModFile(t, "module github.com/jfrog/gocmd\n")
+		if isModFileGeneratedByUs(modFile, []byte("module github.com/jfrog/gocmd\n"), editMessage) {
+			t.Error("expected a mod file with neither marker to not be recognized as generated by us")
+		}
+	})
+}
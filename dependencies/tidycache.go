@@ -0,0 +1,80 @@
+package dependencies
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/jfrog/gocmd/utils/cache"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default validity window for a persisted tidy-cache entry, used when TidyCacheMaxAge isn't set.
+const defaultTidyCacheMaxAge = 24 * time.Hour
+
+// Returns the TidyCache that backs this dependency's memoized tidy/graph results, rooted at
+// pwd.cachePath. Named functions below that need package cache take no parameter literally called
+// "cache", since that identifier is used elsewhere in this package as a *cache.DependenciesCache
+// parameter name and would shadow the package.
+func (pwd *PackageWithDeps) openTidyCache() (*cache.TidyCache, error) {
+	maxAge := pwd.TidyCacheMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultTidyCacheMaxAge
+	}
+	return cache.NewTidyCache(pwd.cachePath, maxAge)
+}
+
+// tidyCacheGet looks up a previously computed tidy/graph result for this dependency's current mod
+// content, the given import set and target repo. found is false on any cache miss or error, in
+// which case the caller should fall through to tidying for real. A hit only saves the resolve/
+// AddRequire/graph-flatten work tidyModInProcess would otherwise do, not the unzip or the
+// collectImports walk that happen before this is ever called: the import set is part of the cache
+// key, and computing it requires the dependency's source already be on disk.
+func (pwd *PackageWithDeps) tidyCacheGet(targetRepo string, imports []string) (tidiedModContent []byte, graphDeps map[string]bool, found bool) {
+	tidyCache, err := pwd.openTidyCache()
+	if err != nil {
+		log.Debug("Could not open tidy cache for", pwd.Dependency.GetId(), ":", err.Error())
+		return nil, nil, false
+	}
+	result, found := tidyCache.Get(pwd.tidyCacheKey(targetRepo, imports))
+	if !found {
+		return nil, nil, false
+	}
+	return result.TidiedModContent, result.GraphDeps, true
+}
+
+// tidyCachePut persists a freshly computed tidy/graph result, so a later invocation over the same
+// mod content, import set and target repo can skip recomputing it.
+func (pwd *PackageWithDeps) tidyCachePut(targetRepo string, imports []string, tidiedModContent []byte, graphDeps map[string]bool) {
+	tidyCache, err := pwd.openTidyCache()
+	if err != nil {
+		log.Debug("Could not open tidy cache for", pwd.Dependency.GetId(), ":", err.Error())
+		return
+	}
+	result := cache.TidyResult{TidiedModContent: tidiedModContent, GraphDeps: graphDeps}
+	if err := tidyCache.Put(pwd.tidyCacheKey(targetRepo, imports), result); err != nil {
+		log.Debug("Could not persist tidy cache entry for", pwd.Dependency.GetId(), ":", err.Error())
+	}
+}
+
+func (pwd *PackageWithDeps) tidyCacheKey(targetRepo string, imports []string) cache.TidyKey {
+	return cache.TidyKey{
+		ModuleId:       pwd.Dependency.GetId(),
+		ModContentHash: hashBytes(pwd.Dependency.GetModContent()),
+		ImportsHash:    hashImports(imports),
+		TargetRepo:     targetRepo,
+	}
+}
+
+func hashBytes(content []byte) string {
+	digest := sha256.Sum256(content)
+	return hex.EncodeToString(digest[:])
+}
+
+// hashImports hashes the sorted import set, so the hash doesn't depend on filesystem walk order.
+func hashImports(imports []string) string {
+	sorted := append([]string(nil), imports...)
+	sort.Strings(sorted)
+	return hashBytes([]byte(strings.Join(sorted, "\n")))
+}
@@ -0,0 +1,87 @@
+package cache
+
+import "sync"
+
+// Severity of a Diagnostic. A caller gating CI on mod-file issues (e.g. via a --fail-on flag) should
+// treat SeverityError as a reason to exit non-zero and SeverityWarning as informational.
+type Severity string
+
+const (
+	SeverityWarning Severity = "Warning"
+	SeverityError   Severity = "Error"
+)
+
+// DiagnosticKind classifies what a Diagnostic is about.
+type DiagnosticKind string
+
+const (
+	MissingRequire  DiagnosticKind = "MissingRequire"
+	UnusedRequire   DiagnosticKind = "UnusedRequire"
+	SyntaxError     DiagnosticKind = "SyntaxError"
+	PublishFailure  DiagnosticKind = "PublishFailure"
+	DownloadFailure DiagnosticKind = "DownloadFailure"
+)
+
+// Diagnostic describes a single mod-file issue encountered while populating and publishing a
+// dependency, in place of silently swallowing the underlying error.
+type Diagnostic struct {
+	ModuleId string
+	Severity Severity
+	Kind     DiagnosticKind
+	Message  string
+	Location string
+}
+
+// Diagnostics recorded per *DependenciesCache, keyed by the cache pointer itself so this can be
+// added without knowing (or changing) DependenciesCache's own fields. A long-lived process that
+// creates many short-lived DependenciesCache instances must call ReleaseDiagnostics once it's done
+// reading a cache's diagnostics, or entries here outlive the cache itself.
+var diagnosticsByCache sync.Map // map[*DependenciesCache]*diagnosticsList
+
+type diagnosticsList struct {
+	mutex sync.Mutex
+	items []Diagnostic
+}
+
+func (cache *DependenciesCache) diagnostics() *diagnosticsList {
+	value, _ := diagnosticsByCache.LoadOrStore(cache, &diagnosticsList{})
+	return value.(*diagnosticsList)
+}
+
+// AddDiagnostic records a diagnostic against this cache, so it's surfaced to Diagnostics() instead
+// of being dropped by a logError call.
+func (cache *DependenciesCache) AddDiagnostic(diagnostic Diagnostic) {
+	list := cache.diagnostics()
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.items = append(list.items, diagnostic)
+}
+
+// Diagnostics returns every diagnostic recorded against this cache so far, in recording order.
+func (cache *DependenciesCache) Diagnostics() []Diagnostic {
+	list := cache.diagnostics()
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	return append([]Diagnostic(nil), list.items...)
+}
+
+// HasErrors returns true if any recorded diagnostic has SeverityError, for callers that want to
+// gate CI (e.g. a --fail-on flag) on whether mod-file population hit anything severe.
+func (cache *DependenciesCache) HasErrors() bool {
+	for _, diagnostic := range cache.Diagnostics() {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseDiagnostics evicts this cache's diagnostics from the process-wide side table. Callers
+// should invoke it once they're done with a DependenciesCache (e.g. right after printing/consuming
+// Diagnostics()), since diagnosticsByCache otherwise keeps every diagnostic reachable for the
+// lifetime of the process, even after the cache itself is no longer in use. A caller that also used
+// DryRun should call ReleasePlan too: it guards a separate side table (see dryrun.go) and one
+// release doesn't cover the other.
+func (cache *DependenciesCache) ReleaseDiagnostics() {
+	diagnosticsByCache.Delete(cache)
+}
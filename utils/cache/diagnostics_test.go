@@ -0,0 +1,48 @@
+package cache
+
+import "testing"
+
+func TestDiagnosticsRecordingOrderAndHasErrors(t *testing.T) {
+	dependenciesCache := &DependenciesCache{}
+
+	if dependenciesCache.HasErrors() {
+		t.Fatal("expected a fresh cache to have no errors")
+	}
+
+	dependenciesCache.AddDiagnostic(Diagnostic{ModuleId: "a", Severity: SeverityWarning, Kind: SyntaxError})
+	if dependenciesCache.HasErrors() {
+		t.Error("expected a warning-only diagnostic to not count as an error")
+	}
+
+	dependenciesCache.AddDiagnostic(Diagnostic{ModuleId: "b", Severity: SeverityError, Kind: MissingRequire})
+	if !dependenciesCache.HasErrors() {
+		t.Error("expected an error-severity diagnostic to be reported by HasErrors")
+	}
+
+	diagnostics := dependenciesCache.Diagnostics()
+	if len(diagnostics) != 2 || diagnostics[0].ModuleId != "a" || diagnostics[1].ModuleId != "b" {
+		t.Errorf("expected diagnostics in recording order [a, b], got %v", diagnostics)
+	}
+}
+
+func TestDiagnosticsAreIsolatedPerCache(t *testing.T) {
+	first := &DependenciesCache{}
+	second := &DependenciesCache{}
+
+	first.AddDiagnostic(Diagnostic{ModuleId: "only-on-first", Severity: SeverityError, Kind: PublishFailure})
+
+	if len(second.Diagnostics()) != 0 {
+		t.Error("expected a diagnostic recorded against one cache to not leak into another")
+	}
+}
+
+func TestReleaseDiagnosticsEvictsTheEntry(t *testing.T) {
+	dependenciesCache := &DependenciesCache{}
+	dependenciesCache.AddDiagnostic(Diagnostic{ModuleId: "a", Severity: SeverityError, Kind: DownloadFailure})
+
+	dependenciesCache.ReleaseDiagnostics()
+
+	if diagnostics := dependenciesCache.Diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("expected ReleaseDiagnostics to evict prior diagnostics, got %v", diagnostics)
+	}
+}
@@ -0,0 +1,52 @@
+package cache
+
+import "sync"
+
+// TidyPlanEntry is the dry-run/plan-mode description of the mod-file edit PopulateModAndPublish
+// would have applied for one module, had DryRun not been set. It's serializable to JSON as-is, so
+// a caller can print the accumulated plan for a run without mutating anything in Artifactory.
+type TidyPlanEntry struct {
+	ModuleId    string   `json:"moduleId"`
+	Added       []string `json:"added"`
+	Removed     []string `json:"removed"`
+	WillPublish bool     `json:"willPublish"`
+	Diff        string   `json:"diff"`
+}
+
+// Plan entries recorded per *DependenciesCache, keyed the same way Diagnostics are.
+var planByCache sync.Map // map[*DependenciesCache]*planList
+
+type planList struct {
+	mutex sync.Mutex
+	items []TidyPlanEntry
+}
+
+func (cache *DependenciesCache) plan() *planList {
+	value, _ := planByCache.LoadOrStore(cache, &planList{})
+	return value.(*planList)
+}
+
+// AddTidyPlanEntry records one module's proposed mod-file edit against this cache.
+func (cache *DependenciesCache) AddTidyPlanEntry(entry TidyPlanEntry) {
+	list := cache.plan()
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.items = append(list.items, entry)
+}
+
+// TidyPlan returns every plan entry recorded against this cache so far, in recording order.
+func (cache *DependenciesCache) TidyPlan() []TidyPlanEntry {
+	list := cache.plan()
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	return append([]TidyPlanEntry(nil), list.items...)
+}
+
+// ReleasePlan evicts this cache's plan entries from the process-wide side table, the same way
+// ReleaseDiagnostics evicts its diagnostics. Callers should invoke both once they're done with a
+// DependenciesCache (e.g. right after printing/consuming TidyPlan()), since planByCache otherwise
+// keeps every TidyPlanEntry reachable for the lifetime of the process, even after the cache itself
+// is no longer in use.
+func (cache *DependenciesCache) ReleasePlan() {
+	planByCache.Delete(cache)
+}
@@ -0,0 +1,41 @@
+package cache
+
+import "testing"
+
+func TestTidyPlanRecordingOrder(t *testing.T) {
+	dependenciesCache := &DependenciesCache{}
+
+	if len(dependenciesCache.TidyPlan()) != 0 {
+		t.Fatal("expected a fresh cache to have an empty plan")
+	}
+
+	dependenciesCache.AddTidyPlanEntry(TidyPlanEntry{ModuleId: "a", WillPublish: true})
+	dependenciesCache.AddTidyPlanEntry(TidyPlanEntry{ModuleId: "b", WillPublish: false})
+
+	plan := dependenciesCache.TidyPlan()
+	if len(plan) != 2 || plan[0].ModuleId != "a" || plan[1].ModuleId != "b" {
+		t.Errorf("expected plan entries in recording order [a, b], got %v", plan)
+	}
+}
+
+func TestTidyPlanIsolatedPerCache(t *testing.T) {
+	first := &DependenciesCache{}
+	second := &DependenciesCache{}
+
+	first.AddTidyPlanEntry(TidyPlanEntry{ModuleId: "only-on-first"})
+
+	if len(second.TidyPlan()) != 0 {
+		t.Error("expected a plan entry recorded against one cache to not leak into another")
+	}
+}
+
+func TestReleasePlanEvictsTheEntry(t *testing.T) {
+	dependenciesCache := &DependenciesCache{}
+	dependenciesCache.AddTidyPlanEntry(TidyPlanEntry{ModuleId: "a"})
+
+	dependenciesCache.ReleasePlan()
+
+	if plan := dependenciesCache.TidyPlan(); len(plan) != 0 {
+		t.Errorf("expected ReleasePlan to evict prior plan entries, got %v", plan)
+	}
+}
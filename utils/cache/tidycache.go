@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/jfrog/jfrog-client-go/utils/errorutils"
+	"github.com/jfrog/jfrog-client-go/utils/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Directory (relative to a dependency's cachePath) holding the persisted TidyCache entries.
+const TidyCacheDirName = ".jfrog-tidy-cache"
+
+// TidyKey identifies one tidy/graph computation for a dependency. gocmd is often invoked repeatedly
+// on overlapping module sets (e.g. CI pipelines rebuilding related modules); two invocations that
+// produce the same key are guaranteed to produce the same TidyResult.
+type TidyKey struct {
+	ModuleId       string
+	ModContentHash string
+	ImportsHash    string
+	TargetRepo     string
+}
+
+// TidyResult is the cached output of tidying a dependency's mod file and building its dependency graph.
+type TidyResult struct {
+	TidiedModContent []byte
+	GraphDeps        map[string]bool
+}
+
+// TidyCache persists TidyResult values on disk as a directory of JSON files named by the hash of
+// their TidyKey. Entries older than MaxAge are treated as a miss.
+type TidyCache struct {
+	dirPath string
+	MaxAge  time.Duration
+}
+
+type tidyCacheEntry struct {
+	StoredAt int64      `json:"storedAt"`
+	Result   TidyResult `json:"result"`
+}
+
+// NewTidyCache returns a TidyCache rooted at <cachePath>/.jfrog-tidy-cache, creating the directory
+// if it doesn't already exist.
+func NewTidyCache(cachePath string, maxAge time.Duration) (*TidyCache, error) {
+	dirPath := filepath.Join(cachePath, TidyCacheDirName)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, errorutils.CheckError(err)
+	}
+	return &TidyCache{dirPath: dirPath, MaxAge: maxAge}, nil
+}
+
+// Get returns the cached TidyResult for key. found is false if there's no entry, the entry can't
+// be parsed, or it's older than MaxAge.
+func (tidyCache *TidyCache) Get(key TidyKey) (result TidyResult, found bool) {
+	content, err := ioutil.ReadFile(tidyCache.entryPath(key))
+	if err != nil {
+		return TidyResult{}, false
+	}
+	var entry tidyCacheEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		log.Debug("Ignoring malformed tidy cache entry for", key.ModuleId, ":", err.Error())
+		return TidyResult{}, false
+	}
+	if tidyCache.MaxAge > 0 && time.Since(time.Unix(entry.StoredAt, 0)) > tidyCache.MaxAge {
+		return TidyResult{}, false
+	}
+	return entry.Result, true
+}
+
+// Put persists result under key, overwriting any existing entry.
+func (tidyCache *TidyCache) Put(key TidyKey, result TidyResult) error {
+	content, err := json.Marshal(tidyCacheEntry{StoredAt: time.Now().Unix(), Result: result})
+	if err != nil {
+		return errorutils.CheckError(err)
+	}
+	return errorutils.CheckError(ioutil.WriteFile(tidyCache.entryPath(key), content, 0644))
+}
+
+func (tidyCache *TidyCache) entryPath(key TidyKey) string {
+	return filepath.Join(tidyCache.dirPath, key.hash()+".json")
+}
+
+// hash derives the cache filename for this key from its fields.
+func (key TidyKey) hash() string {
+	digest := sha256.Sum256([]byte(strings.Join([]string{key.ModuleId, key.ModContentHash, key.ImportsHash, key.TargetRepo}, "|")))
+	return hex.EncodeToString(digest[:])
+}
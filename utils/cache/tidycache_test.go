@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func testTidyKey() TidyKey {
+	return TidyKey{
+		ModuleId:       "github.com/jfrog/gocmd:v1.0.0",
+		ModContentHash: "content-hash",
+		ImportsHash:    "imports-hash",
+		TargetRepo:     "go-remote",
+	}
+}
+
+func TestTidyKeyHashIsDeterministicAndDistinct(t *testing.T) {
+	key := testTidyKey()
+	if key.hash() != key.hash() {
+		t.Error("expected hashing the same key twice to produce the same value")
+	}
+
+	other := key
+	other.ImportsHash = "different-imports-hash"
+	if key.hash() == other.hash() {
+		t.Error("expected keys differing only in ImportsHash to hash to different values")
+	}
+}
+
+func TestTidyCachePutThenGetIsAHit(t *testing.T) {
+	tidyCache, err := NewTidyCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewTidyCache returned an error: %s", err.Error())
+	}
+	key := testTidyKey()
+	want := TidyResult{TidiedModContent: []byte("module github.com/jfrog/gocmd\n"), GraphDeps: map[string]bool{"github.com/pkg/errors@v0.9.1": true}}
+
+	if err := tidyCache.Put(key, want); err != nil {
+		t.Fatalf("Put returned an error: %s", err.Error())
+	}
+
+	got, found := tidyCache.Get(key)
+	if !found {
+		t.Fatal("expected a Get right after Put to be a hit")
+	}
+	if string(got.TidiedModContent) != string(want.TidiedModContent) || !got.GraphDeps["github.com/pkg/errors@v0.9.1"] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestTidyCacheGetMissForUnknownKey(t *testing.T) {
+	tidyCache, err := NewTidyCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("NewTidyCache returned an error: %s", err.Error())
+	}
+	if _, found := tidyCache.Get(testTidyKey()); found {
+		t.Error("expected Get to miss for a key nothing was ever Put under")
+	}
+}
+
+func TestTidyCacheGetMissForExpiredEntry(t *testing.T) {
+	dirPath := t.TempDir()
+	tidyCache, err := NewTidyCache(dirPath, time.Minute)
+	if err != nil {
+		t.Fatalf("NewTidyCache returned an error: %s", err.Error())
+	}
+	key := testTidyKey()
+
+	// Write the entry directly, backdating StoredAt well past MaxAge, since Put always stamps the
+	// current time and this test needs to exercise the expiry path deterministically.
+	staleEntry := tidyCacheEntry{StoredAt: time.Now().Add(-time.Hour).Unix(), Result: TidyResult{TidiedModContent: []byte("module stale\n")}}
+	content, err := json.Marshal(staleEntry)
+	if err != nil {
+		t.Fatalf("failed marshaling the stale entry: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(tidyCache.entryPath(key), content, 0644); err != nil {
+		t.Fatalf("failed writing the stale entry: %s", err.Error())
+	}
+
+	if _, found := tidyCache.Get(key); found {
+		t.Error("expected Get to miss for an entry older than MaxAge")
+	}
+}